@@ -0,0 +1,490 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of the whole API
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Providers ProvidersConfig `yaml:"providers"`
+	Log       LogConfig       `yaml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Mail      MailConfig      `yaml:"mail"`
+}
+
+type ServerConfig struct {
+	Port           string        `yaml:"port"`
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	ReportWorkers  int           `yaml:"report_workers"`
+	// ShutdownTimeout bounds the draining of the requests and of the queued
+	// reports on SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// LegacyRoutes keeps serving the routes of /v1 at the root, deprecated
+	LegacyRoutes bool `yaml:"legacy_routes"`
+	// ProxyHeader is the header holding the IP address of the client behind
+	// a load balancer, e.g. X-Forwarded-For, the peer address is used if empty
+	ProxyHeader string `yaml:"proxy_header"`
+	// ProxyHops is the number of proxies appending to ProxyHeader. The client
+	// is the address appended by the outermost one, counted from the end, as
+	// the entries before it are sent by the client.
+	ProxyHops int `yaml:"proxy_hops"`
+}
+
+type DatabaseConfig struct {
+	User              string        `yaml:"user"`
+	Password          string        `yaml:"password"`
+	Host              string        `yaml:"host"`
+	Port              string        `yaml:"port"`
+	Name              string        `yaml:"name"`
+	MaxConns          int           `yaml:"max_conns"`
+	MinConns          int           `yaml:"min_conns"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period"`
+	ConnectRetries    int           `yaml:"connect_retries"`
+	AutoMigrate       bool          `yaml:"auto_migrate"`
+}
+
+type AuthConfig struct {
+	JWTSecret string        `yaml:"jwt_secret"`
+	TokenTTL  time.Duration `yaml:"token_ttl"`
+	// DeletionGracePeriod is how long a confirmed account deletion can be
+	// canceled before the account is deleted
+	DeletionGracePeriod time.Duration `yaml:"deletion_grace_period"`
+}
+
+type ProvidersConfig struct {
+	GoogleMapsAPIKey string        `yaml:"google_maps_api_key"`
+	GeocodingURL     string        `yaml:"geocoding_url"`
+	ImpactCO2URL     string        `yaml:"impactco2_url"`
+	HTTPTimeout      time.Duration `yaml:"http_timeout"`
+	// GeocodingCacheSize is the number of geocoded addresses kept in memory,
+	// the cache is disabled when 0
+	GeocodingCacheSize int           `yaml:"geocoding_cache_size"`
+	GeocodingCacheTTL  time.Duration `yaml:"geocoding_cache_ttl"`
+}
+
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is json, or text for local development
+	Format string `yaml:"format"`
+}
+
+type TracingConfig struct {
+	// Endpoint is the URL of the OTLP/HTTP collector, e.g.
+	// http://localhost:4318, tracing is disabled when empty
+	Endpoint    string  `yaml:"endpoint"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type MetricsConfig struct {
+	// Port is the admin port /metrics is served on, not served when empty
+	Port string `yaml:"port"`
+	// Token, when set, also serves /metrics on the API port to the requests
+	// sending it as a bearer token, for hosts exposing a single port
+	Token string `yaml:"token"`
+}
+
+type RateLimitConfig struct {
+	// Backend keeps the buckets and lockouts in memory, per instance, or in
+	// postgres, shared by the instances
+	Backend string `yaml:"backend"`
+	// IP limits the requests of each IP address and User those of each
+	// signed-in user
+	IP   Rate `yaml:"ip"`
+	User Rate `yaml:"user"`
+	// Auth limits the logins and registrations of each IP address
+	Auth Rate `yaml:"auth"`
+	// Geocoding limits the trips created and compared by each user, which
+	// may geocode their addresses
+	Geocoding Rate `yaml:"geocoding"`
+	// An email is locked out from an address for LockoutBase after
+	// LockoutThreshold failed logins in a row, twice as long at each further
+	// failure up to LockoutMax
+	LockoutThreshold int           `yaml:"lockout_threshold"`
+	LockoutBase      time.Duration `yaml:"lockout_base"`
+	LockoutMax       time.Duration `yaml:"lockout_max"`
+}
+
+type StorageConfig struct {
+	// Backend stores the blobs, e.g. the avatars, on the local disk
+	Backend string `yaml:"backend"`
+	// Dir is the directory of the local backend
+	Dir string `yaml:"dir"`
+}
+
+type MailConfig struct {
+	// SMTPAddr is the host:port of the SMTP server, the emails are only
+	// logged when empty
+	SMTPAddr string `yaml:"smtp_addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender address of the emails
+	From string `yaml:"from"`
+}
+
+// Default returns the configuration used for the settings that are not set
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            "3000",
+			RequestTimeout:  15 * time.Second,
+			ReportWorkers:   2,
+			ShutdownTimeout: 20 * time.Second,
+			LegacyRoutes:    true,
+			ProxyHops:       1,
+		},
+		Database: DatabaseConfig{
+			Port:              "5432",
+			MaxConns:          10,
+			MinConns:          1,
+			MaxConnLifetime:   time.Hour,
+			MaxConnIdleTime:   30 * time.Minute,
+			HealthCheckPeriod: time.Minute,
+			ConnectRetries:    5,
+			AutoMigrate:       true,
+		},
+		Auth: AuthConfig{
+			TokenTTL:            24 * time.Hour,
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+		},
+		Providers: ProvidersConfig{
+			GeocodingURL: "https://maps.googleapis.com/maps/api/geocode/json",
+			ImpactCO2URL: "https://impactco2.fr/api/v1/transport",
+			HTTPTimeout:  30 * time.Second,
+
+			GeocodingCacheSize: 1000,
+			GeocodingCacheTTL:  24 * time.Hour,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: TracingConfig{
+			ServiceName: "smarteco-api",
+			SampleRatio: 1,
+		},
+		Metrics: MetricsConfig{
+			Port: "9090",
+		},
+		RateLimit: RateLimitConfig{
+			Backend:          "memory",
+			IP:               Rate{Count: 300, Per: time.Minute},
+			User:             Rate{Count: 120, Per: time.Minute},
+			Auth:             Rate{Count: 10, Per: time.Minute},
+			Geocoding:        Rate{Count: 60, Per: time.Hour},
+			LockoutThreshold: 5,
+			LockoutBase:      time.Minute,
+			LockoutMax:       time.Hour,
+		},
+		Storage: StorageConfig{
+			Backend: "local",
+			Dir:     "data",
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, then the optional YAML file
+// named by CONFIG_FILE (config.yaml if present), then the environment. A .env
+// file, when present, fills environment variables that are not already set.
+func Load() (Config, error) {
+	cfg := Default()
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cfg, fmt.Errorf("failed to load .env file: %w", err)
+	}
+
+	path, explicit := os.LookupEnv("CONFIG_FILE")
+	if !explicit {
+		path = "config.yaml"
+	}
+	if content, err := os.ReadFile(path); err == nil {
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	} else if explicit || !errors.Is(err, os.ErrNotExist) {
+		return cfg, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides the configuration with the environment variables that are set
+func applyEnv(cfg *Config) error {
+	var errs []error
+	str := func(name string, dst *string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*dst = value
+		}
+	}
+	integer := func(name string, dst *int) {
+		if value, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %q is not an integer", name, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	duration := func(name string, dst *time.Duration) {
+		if value, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %q is not a duration", name, value))
+				return
+			}
+			*dst = d
+		}
+	}
+	float := func(name string, dst *float64) {
+		if value, ok := os.LookupEnv(name); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %q is not a number", name, value))
+				return
+			}
+			*dst = f
+		}
+	}
+	rate := func(name string, dst *Rate) {
+		if value, ok := os.LookupEnv(name); ok {
+			r, err := ParseRate(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+				return
+			}
+			*dst = r
+		}
+	}
+	boolean := func(name string, dst *bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %q is not a boolean", name, value))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	str("PORT", &cfg.Server.Port)
+	duration("REQUEST_TIMEOUT", &cfg.Server.RequestTimeout)
+	integer("REPORT_WORKERS", &cfg.Server.ReportWorkers)
+	duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	boolean("LEGACY_ROUTES", &cfg.Server.LegacyRoutes)
+	str("PROXY_HEADER", &cfg.Server.ProxyHeader)
+	integer("PROXY_HOPS", &cfg.Server.ProxyHops)
+
+	str("DB_USER", &cfg.Database.User)
+	str("DB_PASSWORD", &cfg.Database.Password)
+	str("DB_HOST", &cfg.Database.Host)
+	str("DB_PORT", &cfg.Database.Port)
+	str("DB_NAME", &cfg.Database.Name)
+	integer("DB_MAX_CONNS", &cfg.Database.MaxConns)
+	integer("DB_MIN_CONNS", &cfg.Database.MinConns)
+	duration("DB_MAX_CONN_LIFETIME", &cfg.Database.MaxConnLifetime)
+	duration("DB_MAX_CONN_IDLE_TIME", &cfg.Database.MaxConnIdleTime)
+	duration("DB_HEALTH_CHECK_PERIOD", &cfg.Database.HealthCheckPeriod)
+	integer("DB_CONNECT_RETRIES", &cfg.Database.ConnectRetries)
+	boolean("DB_AUTO_MIGRATE", &cfg.Database.AutoMigrate)
+
+	str("JWT_SECRET", &cfg.Auth.JWTSecret)
+	duration("JWT_TTL", &cfg.Auth.TokenTTL)
+	duration("ACCOUNT_DELETION_GRACE_PERIOD", &cfg.Auth.DeletionGracePeriod)
+
+	str("GOOGLE_MAPS_API_KEY", &cfg.Providers.GoogleMapsAPIKey)
+	str("GEOCODING_URL", &cfg.Providers.GeocodingURL)
+	str("IMPACTCO2_URL", &cfg.Providers.ImpactCO2URL)
+	duration("HTTP_TIMEOUT", &cfg.Providers.HTTPTimeout)
+	integer("GEOCODING_CACHE_SIZE", &cfg.Providers.GeocodingCacheSize)
+	duration("GEOCODING_CACHE_TTL", &cfg.Providers.GeocodingCacheTTL)
+
+	str("LOG_LEVEL", &cfg.Log.Level)
+	str("LOG_FORMAT", &cfg.Log.Format)
+
+	// the standard OpenTelemetry variables
+	str("OTEL_EXPORTER_OTLP_ENDPOINT", &cfg.Tracing.Endpoint)
+	str("OTEL_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	float("OTEL_TRACES_SAMPLER_ARG", &cfg.Tracing.SampleRatio)
+
+	str("METRICS_PORT", &cfg.Metrics.Port)
+	str("METRICS_TOKEN", &cfg.Metrics.Token)
+
+	str("RATE_LIMIT_BACKEND", &cfg.RateLimit.Backend)
+	rate("RATE_LIMIT_IP", &cfg.RateLimit.IP)
+	rate("RATE_LIMIT_USER", &cfg.RateLimit.User)
+	rate("RATE_LIMIT_AUTH", &cfg.RateLimit.Auth)
+	rate("RATE_LIMIT_GEOCODING", &cfg.RateLimit.Geocoding)
+	integer("LOGIN_LOCKOUT_THRESHOLD", &cfg.RateLimit.LockoutThreshold)
+	duration("LOGIN_LOCKOUT_BASE", &cfg.RateLimit.LockoutBase)
+	duration("LOGIN_LOCKOUT_MAX", &cfg.RateLimit.LockoutMax)
+
+	str("STORAGE_BACKEND", &cfg.Storage.Backend)
+	str("STORAGE_DIR", &cfg.Storage.Dir)
+
+	str("SMTP_ADDR", &cfg.Mail.SMTPAddr)
+	str("SMTP_USERNAME", &cfg.Mail.Username)
+	str("SMTP_PASSWORD", &cfg.Mail.Password)
+	str("MAIL_FROM", &cfg.Mail.From)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+	if _, err := strconv.Atoi(c.Server.Port); err != nil {
+		errs = append(errs, fmt.Errorf("server port %q is not a number", c.Server.Port))
+	}
+	if c.Server.RequestTimeout <= 0 {
+		errs = append(errs, errors.New("server request timeout must be positive"))
+	}
+	if c.Server.ReportWorkers < 1 {
+		errs = append(errs, errors.New("server report workers must be at least 1"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server shutdown timeout must be positive"))
+	}
+	if c.Server.ProxyHops < 1 {
+		errs = append(errs, errors.New("server proxy hops must be at least 1"))
+	}
+
+	if c.Database.Host == "" {
+		errs = append(errs, errors.New("database host is required (DB_HOST)"))
+	}
+	if c.Database.User == "" {
+		errs = append(errs, errors.New("database user is required (DB_USER)"))
+	}
+	if c.Database.Name == "" {
+		errs = append(errs, errors.New("database name is required (DB_NAME)"))
+	}
+	if c.Database.MaxConns < 1 {
+		errs = append(errs, errors.New("database max conns must be at least 1"))
+	}
+	if c.Database.MinConns < 0 || c.Database.MinConns > c.Database.MaxConns {
+		errs = append(errs, fmt.Errorf("database min conns must be between 0 and max conns (%d)", c.Database.MaxConns))
+	}
+	if c.Database.ConnectRetries < 0 {
+		errs = append(errs, errors.New("database connect retries must not be negative"))
+	}
+
+	if len(c.Auth.JWTSecret) < 32 {
+		errs = append(errs, errors.New("JWT secret must be at least 32 characters (JWT_SECRET)"))
+	}
+	if c.Auth.TokenTTL <= 0 {
+		errs = append(errs, errors.New("JWT token TTL must be positive"))
+	}
+	if c.Auth.DeletionGracePeriod < 0 {
+		errs = append(errs, errors.New("account deletion grace period must not be negative"))
+	}
+
+	if c.Providers.HTTPTimeout <= 0 {
+		errs = append(errs, errors.New("providers HTTP timeout must be positive"))
+	}
+	if c.Providers.GeocodingCacheSize < 0 {
+		errs = append(errs, errors.New("providers geocoding cache size must not be negative"))
+	}
+	if c.Providers.GeocodingCacheSize > 0 && c.Providers.GeocodingCacheTTL <= 0 {
+		errs = append(errs, errors.New("providers geocoding cache TTL must be positive"))
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log level %q must be debug, info, warn or error", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("log format %q must be json or text", c.Log.Format))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing sample ratio must be between 0 and 1"))
+	}
+
+	if c.Metrics.Port != "" {
+		if _, err := strconv.Atoi(c.Metrics.Port); err != nil {
+			errs = append(errs, fmt.Errorf("metrics port %q is not a number", c.Metrics.Port))
+		} else if c.Metrics.Port == c.Server.Port {
+			errs = append(errs, errors.New("metrics port must differ from the server port"))
+		}
+	}
+	if c.Metrics.Token != "" && len(c.Metrics.Token) < 16 {
+		errs = append(errs, errors.New("metrics token must be at least 16 characters (METRICS_TOKEN)"))
+	}
+
+	if c.RateLimit.Backend != "memory" && c.RateLimit.Backend != "postgres" {
+		errs = append(errs, fmt.Errorf("rate limit backend %q must be memory or postgres", c.RateLimit.Backend))
+	}
+	if c.RateLimit.LockoutThreshold < 0 {
+		errs = append(errs, errors.New("login lockout threshold must not be negative"))
+	}
+	if c.RateLimit.LockoutThreshold > 0 && (c.RateLimit.LockoutBase <= 0 || c.RateLimit.LockoutMax < c.RateLimit.LockoutBase) {
+		errs = append(errs, errors.New("login lockout base must be positive and at most the lockout max"))
+	}
+
+	if c.Storage.Backend != "local" {
+		errs = append(errs, fmt.Errorf("storage backend %q must be local", c.Storage.Backend))
+	}
+	if c.Storage.Backend == "local" && c.Storage.Dir == "" {
+		errs = append(errs, errors.New("storage dir is required by the local backend (STORAGE_DIR)"))
+	}
+	if c.Mail.SMTPAddr != "" && c.Mail.From == "" {
+		errs = append(errs, errors.New("mail sender is required with an SMTP server (MAIL_FROM)"))
+	}
+	return errors.Join(errs...)
+}
+
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with the secrets hidden
+func (c Config) Redacted() Config {
+	redact := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return redacted
+	}
+	c.Database.Password = redact(c.Database.Password)
+	c.Auth.JWTSecret = redact(c.Auth.JWTSecret)
+	c.Providers.GoogleMapsAPIKey = redact(c.Providers.GoogleMapsAPIKey)
+	c.Metrics.Token = redact(c.Metrics.Token)
+	c.Mail.Password = redact(c.Mail.Password)
+	return c
+}
+
+// String formats the configuration with the secrets hidden, so it is safe to log
+func (c Config) String() string {
+	// plain has no String method, so formatting it does not recurse
+	type plain Config
+	return fmt.Sprintf("%+v", plain(c.Redacted()))
+}
+
+// DSN returns the connection string of the database
+func (d DatabaseConfig) DSN() string {
+	quote := func(value string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+	}
+	return fmt.Sprintf("host=%s user=%s password=%s port=%s dbname=%s",
+		quote(d.Host), quote(d.User), quote(d.Password), quote(d.Port), quote(d.Name))
+}
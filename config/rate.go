@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a rate limit of Count requests Per duration, written "10/1m". The
+// zero Rate, written "0" or "", disables the limit.
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// ParseRate parses a rate written "<count>/<duration>", e.g. "300/1m"
+func ParseRate(value string) (Rate, error) {
+	if value == "" || value == "0" {
+		return Rate{}, nil
+	}
+	count, per, ok := strings.Cut(value, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("%q is not a rate like 10/1m", value)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("%q is not a rate like 10/1m", value)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("%q is not a rate like 10/1m", value)
+	}
+	return Rate{Count: n, Per: d}, nil
+}
+
+// Enabled reports whether the rate limits anything
+func (r Rate) Enabled() bool {
+	return r.Count > 0 && r.Per > 0
+}
+
+// PerSecond returns the number of requests allowed per second
+func (r Rate) PerSecond() float64 {
+	return float64(r.Count) / r.Per.Seconds()
+}
+
+func (r Rate) String() string {
+	if !r.Enabled() {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", r.Count, r.Per)
+}
+
+// UnmarshalText parses the rates of the YAML file
+func (r *Rate) UnmarshalText(text []byte) error {
+	rate, err := ParseRate(string(text))
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"API/models"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+// DeletionTokenTTL is how long the token confirming the deletion of an account
+// can be used
+const DeletionTokenTTL = 24 * time.Hour
+
+// RequestAccountDeletion checks the password of the user and returns the
+// token confirming the deletion of the account, to send to their email
+func (s *Store) RequestAccountDeletion(ctx context.Context, userID int, password string) (string, error) {
+	user, err := s.Users.GetByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if err := checkPassword(user, password); err != nil {
+		return "", err
+	}
+
+	deletion, err := s.AccountDeletions.GetByUser(ctx, userID)
+	if err != nil && !errors.Is(err, ErrDeletionNotFound) {
+		return "", err
+	}
+	if deletion != nil && deletion.ScheduledAt != nil {
+		return "", ErrDeletionScheduled
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	err = s.AccountDeletions.Upsert(ctx, models.AccountDeletion{
+		UserID:         userID,
+		TokenHash:      hashToken(token),
+		TokenExpiresAt: time.Now().Add(DeletionTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConfirmAccountDeletion schedules the deletion of the account after the
+// grace period, given the token of RequestAccountDeletion
+func (s *Store) ConfirmAccountDeletion(ctx context.Context, userID int, token string, grace time.Duration) (*models.AccountDeletion, error) {
+	deletion, err := s.AccountDeletions.GetByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if deletion.ScheduledAt != nil {
+		return nil, ErrDeletionScheduled
+	}
+	if subtle.ConstantTimeCompare([]byte(deletion.TokenHash), []byte(hashToken(token))) != 1 || time.Now().After(deletion.TokenExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	scheduledAt := time.Now().Add(grace)
+	deletion.ScheduledAt = &scheduledAt
+	if err := s.AccountDeletions.Upsert(ctx, *deletion); err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// DeleteUserData deletes the user and every row about them, in a transaction,
+// and returns the deleted user. The entries of the audit log are kept but
+// anonymized. The failed logins, kept by email outside of the transaction, are
+// for the caller to reset once it is committed.
+func (s *Store) DeleteUserData(ctx context.Context, userID int) (*models.User, error) {
+	var user *models.User
+	err := s.WithTx(ctx, func(tx *Store) error {
+		var err error
+		user, err = tx.Users.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		// the rows are deleted explicitly rather than by the foreign keys,
+		// which the databases created by hand may lack
+		deletes := []func(ctx context.Context, userID int) error{
+			tx.Trips.DeleteByUser,
+			tx.Notifications.DeleteByUser,
+			tx.Budgets.DeleteByUser,
+			tx.Participations.DeleteByUser,
+			tx.Recommendations.DeleteByUser,
+			tx.EmailChanges.DeleteByUser,
+			tx.AccountDeletions.DeleteByUser,
+			tx.ReportJobs.DeleteByUser,
+			tx.Audit.AnonymizeUser,
+		}
+		for _, deleteRows := range deletes {
+			if err := deleteRows(ctx, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Users.Delete(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ExportUserData gathers everything stored about the user
+func (s *Store) ExportUserData(ctx context.Context, userID int) (*models.UserData, error) {
+	user, err := s.Users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := &models.UserData{User: *user, Preferences: user.Preferences()}
+
+	if data.EmailChange, err = s.EmailChanges.GetByUser(ctx, userID); err != nil && !errors.Is(err, ErrEmailChangeNotFound) {
+		return nil, err
+	}
+	if data.AccountDeletion, err = s.AccountDeletions.GetByUser(ctx, userID); err != nil && !errors.Is(err, ErrDeletionNotFound) {
+		return nil, err
+	}
+	if data.Budget, err = s.Budgets.GetByUser(ctx, userID); err != nil && !errors.Is(err, ErrBudgetNotFound) {
+		return nil, err
+	}
+	if data.Trips, err = s.Trips.GetByUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.Notifications, err = s.Notifications.GetByUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.Participations, err = s.Participations.GetByUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.Recommendations, err = s.Recommendations.GetByUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// checkPassword returns ErrWrongPassword unless password is the one of user
+func checkPassword(user *models.User, password string) error {
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+type postgresAccountDeletionRepository struct {
+	db querier
+}
+
+const accountDeletionColumns = `user_id, token_hash, token_expires_at, scheduled_at, created_at`
+
+func scanAccountDeletion(row pgx.Row) (models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	err := row.Scan(&deletion.UserID, &deletion.TokenHash, &deletion.TokenExpiresAt, &deletion.ScheduledAt, &deletion.CreatedAt)
+	return deletion, err
+}
+
+func (r *postgresAccountDeletionRepository) Upsert(ctx context.Context, deletion models.AccountDeletion) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO account_deletions (user_id, token_hash, token_expires_at, scheduled_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, token_expires_at = EXCLUDED.token_expires_at,
+			scheduled_at = EXCLUDED.scheduled_at`
+	_, err := r.db.Exec(ctx, query, deletion.UserID, deletion.TokenHash, deletion.TokenExpiresAt, deletion.ScheduledAt, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to set account deletion: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresAccountDeletionRepository) GetByUser(ctx context.Context, userID int) (*models.AccountDeletion, error) {
+	return r.getByUser(ctx, userID, "")
+}
+
+func (r *postgresAccountDeletionRepository) GetByUserForUpdate(ctx context.Context, userID int) (*models.AccountDeletion, error) {
+	return r.getByUser(ctx, userID, " FOR UPDATE")
+}
+
+func (r *postgresAccountDeletionRepository) getByUser(ctx context.Context, userID int, lock string) (*models.AccountDeletion, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + accountDeletionColumns + ` FROM account_deletions WHERE user_id = $1` + lock
+	deletion, err := scanAccountDeletion(r.db.QueryRow(ctx, query, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDeletionNotFound
+		}
+		return nil, fmt.Errorf("failed to get account deletion: %w", err)
+	}
+	return &deletion, nil
+}
+
+func (r *postgresAccountDeletionRepository) Due(ctx context.Context, now time.Time) ([]models.AccountDeletion, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + accountDeletionColumns + ` FROM account_deletions WHERE scheduled_at <= $1 ORDER BY scheduled_at`
+	rows, err := r.db.Query(ctx, query, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account deletions: %w", err)
+	}
+	defer rows.Close()
+	deletions := []models.AccountDeletion{}
+	for rows.Next() {
+		deletion, err := scanAccountDeletion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get account deletion: %w", err)
+		}
+		deletions = append(deletions, deletion)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get account deletions: %w", err)
+	}
+	return deletions, nil
+}
+
+func (r *postgresAccountDeletionRepository) DeleteByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM account_deletions WHERE user_id = $1`
+	if _, err := r.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete account deletion: %w", err)
+	}
+	return nil
+}
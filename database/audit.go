@@ -0,0 +1,61 @@
+package database
+
+import (
+	"API/models"
+	"context"
+	"fmt"
+	"time"
+)
+
+type postgresAuditRepository struct {
+	db querier
+}
+
+func (r *postgresAuditRepository) Create(ctx context.Context, entry models.AuditEntry) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO audit_log (actor_id, action, target_type, target_id, details, request_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)`
+	_, err := r.db.Exec(ctx, query, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, entry.Details, entry.RequestID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create audit entry: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresAuditRepository) List(ctx context.Context, limit, offset int) ([]models.AuditEntry, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT audit_id, actor_id, action, target_type, target_id, details, COALESCE(request_id, ''), created_at
+		FROM audit_log ORDER BY audit_id DESC LIMIT $1 OFFSET $2`
+	rows, err := r.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit entries: %w", err)
+	}
+	defer rows.Close()
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.Scan(&e.AuditID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &e.Details, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to get audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get audit entries: %w", err)
+	}
+	return entries, nil
+}
+
+func (r *postgresAuditRepository) AnonymizeUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE audit_log SET
+			actor_id = NULLIF(actor_id, $1),
+			details = CASE WHEN target_type = 'user' AND target_id = $1 THEN details - 'email' ELSE details END
+		WHERE actor_id = $1 OR (target_type = 'user' AND target_id = $1)`
+	if _, err := r.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to anonymize audit entries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"API/i18n"
+	"API/models"
+	"API/units"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"time"
+)
+
+const (
+	BudgetPeriodMonthly = "monthly"
+	BudgetPeriodYearly  = "yearly"
+
+	// PerCapitaYearlyGoalKg is the 2 t/year per-capita target used when no limit is given
+	PerCapitaYearlyGoalKg = 2000.0
+)
+
+// BudgetThresholds are the usage percentages that trigger a notification
+var BudgetThresholds = []int{50, 80, 100}
+
+// BudgetLimitFromYearlyGoal converts a yearly goal to the limit of the given period
+func BudgetLimitFromYearlyGoal(period string, yearlyGoalKg float64) float64 {
+	if period == BudgetPeriodMonthly {
+		return yearlyGoalKg / 12
+	}
+	return yearlyGoalKg
+}
+
+// BudgetPeriodBounds returns the start and end of the period containing t, in
+// the time zone of t
+func BudgetPeriodBounds(period string, t time.Time) (time.Time, time.Time) {
+	if period == BudgetPeriodMonthly {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(1, 0, 0)
+}
+
+// SetUserBudget creates or replaces the budget of the user and resets its alerts
+func (s *Store) SetUserBudget(ctx context.Context, userID int, period string, limitKg float64) error {
+	if period != BudgetPeriodMonthly && period != BudgetPeriodYearly {
+		return inputError(fmt.Sprintf("invalid budget period: %s", period))
+	}
+	if limitKg <= 0 {
+		return inputError("budget limit must be positive")
+	}
+	return s.Budgets.Upsert(ctx, userID, period, limitKg)
+}
+
+// GetBudgetStatus computes the consumed and projected usage of the user's budget for the current period,
+// which starts at midnight in the time zone of the user
+func (s *Store) GetBudgetStatus(ctx context.Context, userID int) (*models.BudgetStatus, error) {
+	budget, err := s.Budgets.GetByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	trips, err := s.GetUserTrips(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return budgetStatus(budget, trips, time.Now().In(user.Location())), nil
+}
+
+func budgetStatus(budget *models.CarbonBudget, trips []models.Trip, now time.Time) *models.BudgetStatus {
+	start, end := BudgetPeriodBounds(budget.Period, now)
+	status := &models.BudgetStatus{
+		Period:            budget.Period,
+		PeriodStart:       start,
+		PeriodEnd:         end,
+		LimitKg:           budget.LimitKg,
+		ThresholdsReached: []int{},
+	}
+	for _, trip := range trips {
+		if !trip.TripDate.Before(start) && trip.TripDate.Before(end) {
+			status.ConsumedKg += *trip.CarbonImpactKg
+		}
+	}
+	status.RemainingKg = status.LimitKg - status.ConsumedKg
+	status.UsedPercent = status.ConsumedKg / status.LimitKg * 100
+
+	// project the current pace to the end of the period
+	elapsed := now.Sub(start)
+	if elapsed > 0 {
+		status.ProjectedKg = status.ConsumedKg * float64(end.Sub(start)) / float64(elapsed)
+	}
+	status.ProjectedPercent = status.ProjectedKg / status.LimitKg * 100
+
+	for _, threshold := range BudgetThresholds {
+		if status.UsedPercent >= float64(threshold) {
+			status.ThresholdsReached = append(status.ThresholdsReached, threshold)
+		}
+	}
+	return status
+}
+
+// budgetAlerts are the notifications of the budget thresholds by period
+var budgetAlerts = map[string]string{
+	BudgetPeriodMonthly: "You have used %d%% of your monthly carbon budget (%s %s of %s %s).",
+	BudgetPeriodYearly:  "You have used %d%% of your yearly carbon budget (%s %s of %s %s).",
+}
+
+// CheckBudgetAlerts sends a notification for each budget threshold newly reached in the current period,
+// in the language lang. The budget stays locked while checking so that concurrent trips don't send the same alert twice.
+func (s *Store) CheckBudgetAlerts(ctx context.Context, userID int, lang string) error {
+	return s.WithTx(ctx, func(tx *Store) error {
+		budget, err := tx.Budgets.GetByUserForUpdate(ctx, userID)
+		if errors.Is(err, ErrBudgetNotFound) {
+			// nothing to check when the user has no budget
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		user, err := tx.Users.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		trips, err := tx.GetUserTrips(ctx, userID)
+		if err != nil {
+			return err
+		}
+		status := budgetStatus(budget, trips, time.Now().In(user.Location()))
+
+		// alerts start over with each new period
+		lastThreshold := budget.LastAlertThreshold
+		if budget.AlertPeriodStart == nil || !budget.AlertPeriodStart.Equal(status.PeriodStart) {
+			lastThreshold = 0
+		}
+
+		// in the unit system of the user
+		unit := units.Of(user.Units).Mass
+		newThreshold := lastThreshold
+		for _, threshold := range status.ThresholdsReached {
+			if threshold <= lastThreshold {
+				continue
+			}
+			message := i18n.T(lang, budgetAlerts[budget.Period], threshold,
+				i18n.FormatNumber(lang, units.Mass(status.ConsumedKg, user.Units), 1), unit,
+				i18n.FormatNumber(lang, units.Mass(status.LimitKg, user.Units), 1), unit)
+			if err := tx.Notifications.Create(ctx, userID, NotificationTypeBudgetThreshold, message); err != nil {
+				return err
+			}
+			newThreshold = threshold
+		}
+		if newThreshold == budget.LastAlertThreshold && budget.AlertPeriodStart != nil && budget.AlertPeriodStart.Equal(status.PeriodStart) {
+			return nil
+		}
+
+		return tx.Budgets.UpdateAlertState(ctx, budget.BudgetID, newThreshold, status.PeriodStart)
+	})
+}
+
+type postgresBudgetRepository struct {
+	db querier
+}
+
+func (r *postgresBudgetRepository) GetByUser(ctx context.Context, userID int) (*models.CarbonBudget, error) {
+	return r.getByUser(ctx, userID, "")
+}
+
+func (r *postgresBudgetRepository) GetByUserForUpdate(ctx context.Context, userID int) (*models.CarbonBudget, error) {
+	return r.getByUser(ctx, userID, " FOR UPDATE")
+}
+
+func (r *postgresBudgetRepository) getByUser(ctx context.Context, userID int, lock string) (*models.CarbonBudget, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT budget_id, user_id, period, limit_kg, last_alert_threshold, alert_period_start, created_at, updated_at
+		FROM carbon_budgets WHERE user_id = $1` + lock
+	row := r.db.QueryRow(ctx, query, userID)
+	budget := &models.CarbonBudget{}
+	if err := row.Scan(&budget.BudgetID, &budget.UserID, &budget.Period, &budget.LimitKg, &budget.LastAlertThreshold, &budget.AlertPeriodStart, &budget.CreatedAt, &budget.UpdatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBudgetNotFound
+		}
+		return nil, fmt.Errorf("failed to get budget: %w", err)
+	}
+	return budget, nil
+}
+
+func (r *postgresBudgetRepository) Upsert(ctx context.Context, userID int, period string, limitKg float64) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO carbon_budgets (user_id, period, limit_kg, last_alert_threshold, alert_period_start, created_at, updated_at)
+		VALUES ($1, $2, $3, 0, NULL, $4, $4)
+		ON CONFLICT (user_id) DO UPDATE SET period = EXCLUDED.period, limit_kg = EXCLUDED.limit_kg,
+			last_alert_threshold = 0, alert_period_start = NULL, updated_at = EXCLUDED.updated_at`
+	_, err := r.db.Exec(ctx, query, userID, period, limitKg, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to set budget: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresBudgetRepository) DeleteByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM carbon_budgets WHERE user_id = $1`
+	_, err := r.db.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete budget: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresBudgetRepository) UpdateAlertState(ctx context.Context, budgetID, threshold int, periodStart time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE carbon_budgets SET last_alert_threshold = $1, alert_period_start = $2 WHERE budget_id = $3`
+	if _, err := r.db.Exec(ctx, query, threshold, periodStart, budgetID); err != nil {
+		slog.ErrorContext(ctx, "Error updating budget alerts", "error", err)
+		return err
+	}
+	return nil
+}
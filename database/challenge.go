@@ -0,0 +1,140 @@
+package database
+
+import (
+	"API/models"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type postgresChallengeRepository struct {
+	db querier
+}
+
+const challengeColumns = `challenge_id, name, description, start_date, end_date, created_at`
+
+func scanChallenge(row pgx.Row) (models.Challenge, error) {
+	var challenge models.Challenge
+	err := row.Scan(&challenge.ChallengeID, &challenge.Name, &challenge.Description,
+		&challenge.StartDate, &challenge.EndDate, &challenge.CreatedAt)
+	return challenge, err
+}
+
+func (r *postgresChallengeRepository) Create(ctx context.Context, challenge *models.Challenge) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	challenge.CreatedAt = time.Now()
+	query := `INSERT INTO challenges (name, description, start_date, end_date, created_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING challenge_id`
+	err := r.db.QueryRow(ctx, query, challenge.Name, challenge.Description, challenge.StartDate, challenge.EndDate, challenge.CreatedAt).
+		Scan(&challenge.ChallengeID)
+	if err != nil {
+		return fmt.Errorf("failed to create challenge: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresChallengeRepository) GetByID(ctx context.Context, challengeID int) (*models.Challenge, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + challengeColumns + ` FROM challenges WHERE challenge_id = $1`
+	challenge, err := scanChallenge(r.db.QueryRow(ctx, query, challengeID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrChallengeNotFound
+		}
+		return nil, fmt.Errorf("failed to get challenge: %w", err)
+	}
+	return &challenge, nil
+}
+
+func (r *postgresChallengeRepository) GetAll(ctx context.Context) ([]models.Challenge, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + challengeColumns + ` FROM challenges ORDER BY start_date DESC, challenge_id`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenges: %w", err)
+	}
+	defer rows.Close()
+	challenges := []models.Challenge{}
+	for rows.Next() {
+		challenge, err := scanChallenge(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get challenge: %w", err)
+		}
+		challenges = append(challenges, challenge)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get challenges: %w", err)
+	}
+	return challenges, nil
+}
+
+func (r *postgresChallengeRepository) Update(ctx context.Context, challenge models.Challenge) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE challenges SET name = $1, description = $2, start_date = $3, end_date = $4 WHERE challenge_id = $5`
+	res, err := r.db.Exec(ctx, query, challenge.Name, challenge.Description, challenge.StartDate, challenge.EndDate, challenge.ChallengeID)
+	if err != nil {
+		return fmt.Errorf("failed to update challenge: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrChallengeNotFound
+	}
+	return nil
+}
+
+func (r *postgresChallengeRepository) Delete(ctx context.Context, challengeID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM challenges WHERE challenge_id = $1`
+	res, err := r.db.Exec(ctx, query, challengeID)
+	if err != nil {
+		return fmt.Errorf("failed to delete challenge: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrChallengeNotFound
+	}
+	return nil
+}
+
+type postgresParticipationRepository struct {
+	db querier
+}
+
+func (r *postgresParticipationRepository) GetByUser(ctx context.Context, userID int) ([]models.ChallengeParticipation, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT participation_id, user_id, challenge_id, progress, completed FROM challengeparticipation
+		WHERE user_id = $1 ORDER BY participation_id`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participations: %w", err)
+	}
+	defer rows.Close()
+	participations := []models.ChallengeParticipation{}
+	for rows.Next() {
+		var p models.ChallengeParticipation
+		if err := rows.Scan(&p.ParticipationID, &p.UserID, &p.ChallengeID, &p.Progress, &p.Completed); err != nil {
+			return nil, fmt.Errorf("failed to get participation: %w", err)
+		}
+		participations = append(participations, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get participations: %w", err)
+	}
+	return participations, nil
+}
+
+func (r *postgresParticipationRepository) DeleteByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM challengeparticipation WHERE user_id = $1`
+	if _, err := r.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete participations: %w", err)
+	}
+	return nil
+}
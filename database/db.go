@@ -1,38 +1,132 @@
 package database
 
 import (
-	"database/sql"
+	"API/config"
+	"API/utils"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/joho/godotenv"
-	"log"
-	"os"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"time"
 )
 
-type Database struct {
-	DB *sql.DB
+// queryTimeout bounds each query, on top of the deadline of the request
+const queryTimeout = 5 * time.Second
+
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// querier is implemented by both *pgxpool.Pool and pgx.Tx so that the
+// repositories run the same queries inside and outside transactions
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
-var DbInstance *Database
+type Database struct {
+	Pool *pgxpool.Pool
+}
 
-func InitDB() error {
-	// Load environment variables from .env file
-	err := godotenv.Load()
+// InitDB opens the connection pool and waits for the database to be reachable
+func InitDB(ctx context.Context, cfg config.DatabaseConfig) (*Database, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.DSN())
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		return nil, fmt.Errorf("failed to parse database config: %w", err)
 	}
+	poolConfig.MaxConns = int32(cfg.MaxConns)
+	poolConfig.MinConns = int32(cfg.MinConns)
+	poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	poolConfig.ConnConfig.Tracer = queryTracer{}
 
-	// Retrieve variables from environment
-	dbUser := os.Getenv("DB_USER")
-	dbPwd := os.Getenv("DB_PASSWORD")
-	dbTCPHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
-	dbURI := fmt.Sprintf("host=%s user=%s password=%s port=%s database=%s",
-		dbTCPHost, dbUser, dbPwd, dbPort, dbName)
-	db, err := sql.Open("pgx", dbURI)
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// the pool connects lazily, make sure the database is reachable before serving
+	if err := pingWithRetry(ctx, pool, cfg.ConnectRetries); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &Database{Pool: pool}, nil
+}
+
+// Close closes all the connections of the pool
+func (d *Database) Close() {
+	d.Pool.Close()
+}
+
+// pingWithRetry pings the database, backing off exponentially between attempts
+func pingWithRetry(ctx context.Context, pool *pgxpool.Pool, retries int) error {
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 0; ; attempt++ {
+		pingCtx, cancel := withQueryTimeout(ctx)
+		err = pool.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return fmt.Errorf("failed to connect to database after %d attempts: %w", attempt+1, err)
+		}
+		slog.WarnContext(ctx, "Database not reachable, retrying", "error", err, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation on the given constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+// isForeignKeyViolation reports whether err is a foreign key violation on the given constraint
+func isForeignKeyViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == constraint
+}
+
+// NewPostgresStore returns a Store backed by the Postgres database
+func NewPostgresStore(db *Database, providers *utils.Client) *Store {
+	s := newPostgresStore(db.Pool, providers)
+	s.withTx = func(ctx context.Context, fn func(tx *Store) error) error {
+		return pgx.BeginFunc(ctx, db.Pool, func(tx pgx.Tx) error {
+			return fn(newPostgresStore(tx, providers))
+		})
+	}
+	s.ping = db.Pool.Ping
+	return s
+}
+
+func newPostgresStore(db querier, providers *utils.Client) *Store {
+	return &Store{
+		Users:            &postgresUserRepository{db: db},
+		EmailChanges:     &postgresEmailChangeRepository{db: db},
+		Trips:            &postgresTripRepository{db: db},
+		Modes:            &postgresTransportationModeRepository{db: db},
+		Budgets:          &postgresBudgetRepository{db: db},
+		Notifications:    &postgresNotificationRepository{db: db},
+		Challenges:       &postgresChallengeRepository{db: db},
+		Audit:            &postgresAuditRepository{db: db},
+		Participations:   &postgresParticipationRepository{db: db},
+		Recommendations:  &postgresRecommendationRepository{db: db},
+		AccountDeletions: &postgresAccountDeletionRepository{db: db},
+		ReportJobs:       &postgresReportJobRepository{db: db},
+		RateLimits:       &postgresRateLimitRepository{db: db},
+		LoginAttempts:    &postgresLoginAttemptRepository{db: db},
+		Providers:        providers,
 	}
-	DbInstance = &Database{DB: db}
-	return nil
 }
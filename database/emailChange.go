@@ -0,0 +1,134 @@
+package database
+
+import (
+	"API/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// EmailChangeTTL is how long the token sent to a new email can be confirmed
+const EmailChangeTTL = 24 * time.Hour
+
+// RequestEmailChange records the new email of the user, kept pending until
+// ConfirmEmailChange, and returns the token to send to it
+func (s *Store) RequestEmailChange(ctx context.Context, userID int, email string) (string, error) {
+	if email == "" {
+		return "", inputError("email is empty")
+	}
+
+	// the unique constraint checks it again on confirmation
+	_, err := s.Users.GetByEmail(ctx, email)
+	if err == nil {
+		return "", ErrEmailExists
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return "", err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	change := models.EmailChange{
+		UserID:    userID,
+		Email:     email,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(EmailChangeTTL),
+	}
+	if err := s.EmailChanges.Upsert(ctx, change); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConfirmEmailChange replaces the email of the user with the pending one the
+// token was sent to, and returns the new email
+func (s *Store) ConfirmEmailChange(ctx context.Context, userID int, token string) (string, error) {
+	var email string
+	err := s.WithTx(ctx, func(tx *Store) error {
+		change, err := tx.EmailChanges.GetByUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(change.TokenHash), []byte(hashToken(token))) != 1 || time.Now().After(change.ExpiresAt) {
+			return ErrInvalidToken
+		}
+
+		user, err := tx.Users.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		user.Email = change.Email
+		if err := tx.Users.Update(ctx, *user); err != nil {
+			return err
+		}
+		email = change.Email
+		return tx.EmailChanges.DeleteByUser(ctx, userID)
+	})
+	return email, err
+}
+
+// newToken returns a random token, hex encoded
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 of the token, stored instead of the token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type postgresEmailChangeRepository struct {
+	db querier
+}
+
+func (r *postgresEmailChangeRepository) Upsert(ctx context.Context, change models.EmailChange) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO email_changes (user_id, email, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, token_hash = EXCLUDED.token_hash,
+			expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at`
+	_, err := r.db.Exec(ctx, query, change.UserID, change.Email, change.TokenHash, change.ExpiresAt, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to set email change: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresEmailChangeRepository) GetByUser(ctx context.Context, userID int) (*models.EmailChange, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT user_id, email, token_hash, expires_at, created_at FROM email_changes WHERE user_id = $1`
+	change := &models.EmailChange{}
+	err := r.db.QueryRow(ctx, query, userID).Scan(&change.UserID, &change.Email, &change.TokenHash, &change.ExpiresAt, &change.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, fmt.Errorf("failed to get email change: %w", err)
+	}
+	return change, nil
+}
+
+func (r *postgresEmailChangeRepository) DeleteByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM email_changes WHERE user_id = $1`
+	if _, err := r.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete email change: %w", err)
+	}
+	return nil
+}
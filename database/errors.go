@@ -0,0 +1,49 @@
+package database
+
+import "errors"
+
+// Errors returned by the Store and the repositories, match them with errors.Is
+var (
+	ErrUserNotFound         = errors.New("user not found")
+	ErrTripNotFound         = errors.New("trip not found")
+	ErrModeNotFound         = errors.New("mode not found")
+	ErrBudgetNotFound       = errors.New("budget not found")
+	ErrNotificationNotFound = errors.New("notification not found")
+	ErrChallengeNotFound    = errors.New("challenge not found")
+	ErrEmailChangeNotFound  = errors.New("no pending email change")
+	ErrDeletionNotFound     = errors.New("no account deletion requested")
+	ErrReportNotFound       = errors.New("report not found")
+
+	ErrEmailExists    = errors.New("email already exists")
+	ErrUsernameExists = errors.New("username already exists")
+	// ErrModeInUse is returned when deleting a mode some trips or baselines
+	// refer to
+	ErrModeInUse = errors.New("mode is used by trips or baselines")
+	// ErrDeletionScheduled is returned when asking again for the deletion of
+	// an account already scheduled for deletion
+	ErrDeletionScheduled = errors.New("account deletion already scheduled")
+
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrWrongPassword is returned when the current password given to change
+	// it is wrong
+	ErrWrongPassword = errors.New("wrong current password")
+	// ErrInvalidToken is returned for a wrong or expired verification token
+	ErrInvalidToken    = errors.New("invalid or expired token")
+	ErrAccountDisabled = errors.New("account disabled")
+	ErrForbidden       = errors.New("forbidden")
+
+	// ErrInvalidInput matches the errors returned for invalid arguments, their
+	// message describes the problem
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+// inputError is an ErrInvalidInput with its own message
+type inputError string
+
+func (e inputError) Error() string {
+	return string(e)
+}
+
+func (e inputError) Is(target error) bool {
+	return target == ErrInvalidInput
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"API/models"
+	"API/utils"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	ForecastMethodAuto                 = "auto"
+	ForecastMethodSeasonalNaive        = "seasonal_naive"
+	ForecastMethodExponentialSmoothing = "exponential_smoothing"
+
+	// smoothing factors used by the exponential smoothing forecast
+	forecastAlpha = 0.5
+	forecastBeta  = 0.3
+	// number of months in the moving average and in each mode share window
+	trendWindow = 3
+)
+
+// ForecastUserEmissions projects the user's emissions over the next horizon months.
+// Only complete months are used as history, so the forecast starts with the current month.
+// The months are the ones of the time zone of the user.
+func (s *Store) ForecastUserEmissions(ctx context.Context, userID int, method string, horizon int) (*models.EmissionForecast, error) {
+	user, err := s.Users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	trips, err := s.Trips.GetByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	loc := user.Location()
+	now := time.Now().In(loc)
+	currentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+
+	// bucket the trips of the complete months
+	var firstMonth time.Time
+	monthly := make(map[string]float64)
+	var pastTrips []models.Trip
+	for _, trip := range trips {
+		date := trip.TripDate.In(loc)
+		month := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, loc)
+		if !month.Before(currentMonth) {
+			continue
+		}
+		if firstMonth.IsZero() || month.Before(firstMonth) {
+			firstMonth = month
+		}
+		monthly[month.Format("2006-01")] += *trip.CarbonImpactKg
+		pastTrips = append(pastTrips, trip)
+	}
+
+	// continuous series from the first month with trips, months without trips count as 0
+	history := []models.MonthlyEmission{}
+	var values []float64
+	if !firstMonth.IsZero() {
+		for month := firstMonth; month.Before(currentMonth); month = month.AddDate(0, 1, 0) {
+			key := month.Format("2006-01")
+			history = append(history, models.MonthlyEmission{Month: key, ImpactKg: monthly[key]})
+			values = append(values, monthly[key])
+		}
+	}
+
+	if method == "" || method == ForecastMethodAuto {
+		method = ForecastMethodExponentialSmoothing
+		if len(values) >= 12 {
+			method = ForecastMethodSeasonalNaive
+		}
+	}
+
+	var projected []float64
+	switch method {
+	case ForecastMethodSeasonalNaive:
+		if len(values) < 12 {
+			return nil, inputError("seasonal forecast requires at least 12 months of history")
+		}
+		projected = utils.SeasonalNaiveForecast(values, 12, horizon)
+	case ForecastMethodExponentialSmoothing:
+		projected = utils.HoltForecast(values, forecastAlpha, forecastBeta, horizon)
+	default:
+		return nil, inputError(fmt.Sprintf("invalid forecast method: %s", method))
+	}
+
+	forecast := &models.EmissionForecast{
+		Method:   method,
+		History:  history,
+		Forecast: make([]models.MonthlyEmission, horizon),
+		Trend:    emissionTrend(history, values, pastTrips, currentMonth),
+	}
+	for i, value := range projected {
+		forecast.Forecast[i] = models.MonthlyEmission{
+			Month:    currentMonth.AddDate(0, i, 0).Format("2006-01"),
+			ImpactKg: value,
+		}
+	}
+	return forecast, nil
+}
+
+func emissionTrend(history []models.MonthlyEmission, values []float64, trips []models.Trip, currentMonth time.Time) models.EmissionTrend {
+	trend := models.EmissionTrend{
+		MovingAverage:  make([]models.MonthlyEmission, len(history)),
+		ModeShareDrift: []models.ModeShareDrift{},
+	}
+	if n := len(values); n >= 2 {
+		if change, ok := utils.PercentChange(values[n-2], values[n-1]); ok {
+			trend.MonthOverMonthChange = &change
+		}
+	}
+	for i, average := range utils.MovingAverage(values, trendWindow) {
+		trend.MovingAverage[i] = models.MonthlyEmission{Month: history[i].Month, ImpactKg: average}
+	}
+
+	// compare the share of each mode in the last window with the window before
+	currentStart := currentMonth.AddDate(0, -trendWindow, 0)
+	previousStart := currentMonth.AddDate(0, -2*trendWindow, 0)
+	previous := make(map[int]float64)
+	current := make(map[int]float64)
+	var previousTotal, currentTotal float64
+	for _, trip := range trips {
+		switch {
+		case !trip.TripDate.Before(currentStart):
+			current[trip.ModeID] += *trip.CarbonImpactKg
+			currentTotal += *trip.CarbonImpactKg
+		case !trip.TripDate.Before(previousStart):
+			previous[trip.ModeID] += *trip.CarbonImpactKg
+			previousTotal += *trip.CarbonImpactKg
+		}
+	}
+	modeIDs := make(map[int]bool)
+	for modeID := range previous {
+		modeIDs[modeID] = true
+	}
+	for modeID := range current {
+		modeIDs[modeID] = true
+	}
+	for modeID := range modeIDs {
+		drift := models.ModeShareDrift{ModeID: modeID}
+		if previousTotal > 0 {
+			drift.PreviousShare = previous[modeID] / previousTotal * 100
+		}
+		if currentTotal > 0 {
+			drift.CurrentShare = current[modeID] / currentTotal * 100
+		}
+		drift.Drift = drift.CurrentShare - drift.PreviousShare
+		trend.ModeShareDrift = append(trend.ModeShareDrift, drift)
+	}
+	sort.Slice(trend.ModeShareDrift, func(i, j int) bool {
+		return trend.ModeShareDrift[i].ModeID < trend.ModeShareDrift[j].ModeID
+	})
+	return trend
+}
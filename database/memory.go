@@ -0,0 +1,911 @@
+package database
+
+import (
+	"API/models"
+	"API/utils"
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryStore returns a Store keeping everything in memory, for tests.
+// The transportation modes are given here.
+// There are no transactions, WithTx runs its function directly.
+func NewMemoryStore(providers *utils.Client, modes ...models.TransportationMode) *Store {
+	m := &memoryDB{
+		users:         make(map[int]models.User),
+		emailChanges:  make(map[int]models.EmailChange),
+		trips:         make(map[int]models.Trip),
+		modes:         make(map[int]models.TransportationMode),
+		budgets:       make(map[int]models.CarbonBudget),
+		notifications: make(map[int]models.Notification),
+		challenges:    make(map[int]models.Challenge),
+		deletions:     make(map[int]models.AccountDeletion),
+		reportJobs:    make(map[memoryReportKey]models.ReportJob),
+	}
+	for _, mode := range modes {
+		m.modes[mode.ModeID] = mode
+	}
+	rateLimits, loginAttempts := NewMemoryLimits()
+	return &Store{
+		Users:            &memoryUserRepository{m},
+		EmailChanges:     &memoryEmailChangeRepository{m},
+		Trips:            &memoryTripRepository{m},
+		Modes:            &memoryTransportationModeRepository{m},
+		Budgets:          &memoryBudgetRepository{m},
+		Notifications:    &memoryNotificationRepository{m},
+		Challenges:       &memoryChallengeRepository{m},
+		Audit:            &memoryAuditRepository{m},
+		Participations:   &memoryParticipationRepository{m},
+		Recommendations:  &memoryRecommendationRepository{m},
+		AccountDeletions: &memoryAccountDeletionRepository{m},
+		ReportJobs:       &memoryReportJobRepository{m},
+		RateLimits:       rateLimits,
+		LoginAttempts:    loginAttempts,
+		Providers:        providers,
+	}
+}
+
+// memoryDB holds the tables shared by the in-memory repositories
+type memoryDB struct {
+	mu            sync.Mutex
+	lastID        int
+	users         map[int]models.User
+	emailChanges  map[int]models.EmailChange
+	trips         map[int]models.Trip
+	modes         map[int]models.TransportationMode
+	budgets       map[int]models.CarbonBudget
+	notifications map[int]models.Notification
+	challenges    map[int]models.Challenge
+	audit         []models.AuditEntry
+	// participations and recommendations are never created by the API
+	participations  []models.ChallengeParticipation
+	recommendations []models.Recommendation
+	deletions       map[int]models.AccountDeletion
+	reportJobs      map[memoryReportKey]models.ReportJob
+}
+
+func (m *memoryDB) nextID() int {
+	m.lastID++
+	return m.lastID
+}
+
+type memoryUserRepository struct{ m *memoryDB }
+
+func (r *memoryUserRepository) Create(ctx context.Context, user models.User) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, u := range r.m.users {
+		if u.Email == user.Email {
+			return 0, ErrEmailExists
+		}
+		if u.Username == user.Username {
+			return 0, ErrUsernameExists
+		}
+	}
+	user.UserID = r.m.nextID()
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	if user.Units == "" {
+		user.Units = models.UnitsMetric
+	}
+	if user.Timezone == "" {
+		user.Timezone = "UTC"
+	}
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	r.m.users[user.UserID] = user
+	return user.UserID, nil
+}
+
+func (r *memoryUserRepository) find(match func(models.User) bool) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, u := range r.m.users {
+		if match(u) {
+			return &u, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (r *memoryUserRepository) GetByID(ctx context.Context, userID int) (*models.User, error) {
+	return r.find(func(u models.User) bool { return u.UserID == userID })
+}
+
+func (r *memoryUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.find(func(u models.User) bool { return u.Email == email })
+}
+
+func (r *memoryUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.find(func(u models.User) bool { return u.Username == username })
+}
+
+func (r *memoryUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	users := []models.User{}
+	for _, u := range r.m.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+	return users, nil
+}
+
+func (r *memoryUserRepository) Update(ctx context.Context, user models.User) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.users[user.UserID]; !ok {
+		return ErrUserNotFound
+	}
+	for _, u := range r.m.users {
+		if u.UserID == user.UserID {
+			continue
+		}
+		if u.Email == user.Email {
+			return ErrEmailExists
+		}
+		if u.Username == user.Username {
+			return ErrUsernameExists
+		}
+	}
+	user.UpdatedAt = time.Now()
+	r.m.users[user.UserID] = user
+	return nil
+}
+
+func (r *memoryUserRepository) UpdateBaselineMode(ctx context.Context, userID, modeID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	user, ok := r.m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.BaselineModeID = &modeID
+	user.UpdatedAt = time.Now()
+	r.m.users[userID] = user
+	return nil
+}
+
+func (r *memoryUserRepository) Search(ctx context.Context, filter UserFilter) ([]models.User, int, error) {
+	users, _ := r.GetAll(ctx)
+	query := strings.ToLower(filter.Query)
+	matching := []models.User{}
+	for _, u := range users {
+		if query != "" && !strings.Contains(strings.ToLower(u.Email), query) && !strings.Contains(strings.ToLower(u.Username), query) {
+			continue
+		}
+		if filter.Role != "" && u.Role != filter.Role {
+			continue
+		}
+		if filter.Disabled != nil && *filter.Disabled != (u.DisabledAt != nil) {
+			continue
+		}
+		matching = append(matching, u)
+	}
+	total := len(matching)
+	start := min(filter.Offset, total)
+	end := min(start+filter.Limit, total)
+	return matching[start:end], total, nil
+}
+
+func (r *memoryUserRepository) update(userID int, change func(user *models.User)) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	user, ok := r.m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	change(&user)
+	user.UpdatedAt = time.Now()
+	r.m.users[userID] = user
+	return nil
+}
+
+func (r *memoryUserRepository) UpdateRole(ctx context.Context, userID int, role string) error {
+	return r.update(userID, func(user *models.User) { user.Role = role })
+}
+
+func (r *memoryUserRepository) UpdateLanguage(ctx context.Context, userID int, language *string) error {
+	return r.update(userID, func(user *models.User) { user.Language = language })
+}
+
+func (r *memoryUserRepository) UpdatePreferences(ctx context.Context, userID int, preferences models.Preferences) error {
+	return r.update(userID, func(user *models.User) {
+		user.Units = preferences.Units
+		user.Timezone = preferences.Timezone
+		user.Language = preferences.Language
+		user.DefaultModeID = preferences.DefaultModeID
+		user.BaselineModeID = preferences.BaselineModeID
+	})
+}
+
+func (r *memoryUserRepository) UpdateAvatar(ctx context.Context, userID int, avatarKey *string) error {
+	return r.update(userID, func(user *models.User) { user.AvatarKey = avatarKey })
+}
+
+func (r *memoryUserRepository) SetDisabled(ctx context.Context, userID int, disabled bool) error {
+	return r.update(userID, func(user *models.User) {
+		switch {
+		case !disabled:
+			user.DisabledAt = nil
+		case user.DisabledAt == nil:
+			now := time.Now()
+			user.DisabledAt = &now
+		}
+	})
+}
+
+func (r *memoryUserRepository) Delete(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delete(r.m.users, userID)
+	delete(r.m.emailChanges, userID)
+	return nil
+}
+
+type memoryEmailChangeRepository struct{ m *memoryDB }
+
+func (r *memoryEmailChangeRepository) Upsert(ctx context.Context, change models.EmailChange) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	change.CreatedAt = time.Now()
+	r.m.emailChanges[change.UserID] = change
+	return nil
+}
+
+func (r *memoryEmailChangeRepository) GetByUser(ctx context.Context, userID int) (*models.EmailChange, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	change, ok := r.m.emailChanges[userID]
+	if !ok {
+		return nil, ErrEmailChangeNotFound
+	}
+	return &change, nil
+}
+
+func (r *memoryEmailChangeRepository) DeleteByUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delete(r.m.emailChanges, userID)
+	return nil
+}
+
+type memoryTripRepository struct{ m *memoryDB }
+
+func (r *memoryTripRepository) Create(ctx context.Context, trip *models.Trip) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	trip.TripID = r.m.nextID()
+	r.m.trips[trip.TripID] = *trip
+	return nil
+}
+
+func (r *memoryTripRepository) GetByID(ctx context.Context, tripID int) (*models.Trip, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	trip, ok := r.m.trips[tripID]
+	if !ok {
+		return nil, ErrTripNotFound
+	}
+	return &trip, nil
+}
+
+func (r *memoryTripRepository) GetByUser(ctx context.Context, userID int) ([]models.Trip, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	trips := []models.Trip{}
+	for _, trip := range r.m.trips {
+		if trip.UserID == userID {
+			trips = append(trips, trip)
+		}
+	}
+	sort.Slice(trips, func(i, j int) bool { return trips[i].TripID < trips[j].TripID })
+	return trips, nil
+}
+
+func (r *memoryTripRepository) Update(ctx context.Context, trip *models.Trip) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.trips[trip.TripID]; !ok {
+		return ErrTripNotFound
+	}
+	r.m.trips[trip.TripID] = *trip
+	return nil
+}
+
+func (r *memoryTripRepository) Delete(ctx context.Context, tripID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delete(r.m.trips, tripID)
+	return nil
+}
+
+func (r *memoryTripRepository) DeleteByUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for id, trip := range r.m.trips {
+		if trip.UserID == userID {
+			delete(r.m.trips, id)
+		}
+	}
+	return nil
+}
+
+type memoryTransportationModeRepository struct{ m *memoryDB }
+
+func (r *memoryTransportationModeRepository) GetByID(ctx context.Context, modeID int) (*models.TransportationMode, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	mode, ok := r.m.modes[modeID]
+	if !ok {
+		return nil, ErrModeNotFound
+	}
+	return &mode, nil
+}
+
+func (r *memoryTransportationModeRepository) GetAll(ctx context.Context) ([]*models.TransportationMode, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	modes := []*models.TransportationMode{}
+	for _, mode := range r.m.modes {
+		mode := mode
+		modes = append(modes, &mode)
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i].ModeID < modes[j].ModeID })
+	return modes, nil
+}
+
+func (r *memoryTransportationModeRepository) Create(ctx context.Context, mode *models.TransportationMode) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	// after the highest ID, like the sequence of the table
+	mode.ModeID = 1
+	for id := range r.m.modes {
+		if id >= mode.ModeID {
+			mode.ModeID = id + 1
+		}
+	}
+	r.m.modes[mode.ModeID] = *mode
+	return nil
+}
+
+func (r *memoryTransportationModeRepository) Update(ctx context.Context, mode models.TransportationMode) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.modes[mode.ModeID]; !ok {
+		return ErrModeNotFound
+	}
+	r.m.modes[mode.ModeID] = mode
+	return nil
+}
+
+func (r *memoryTransportationModeRepository) Delete(ctx context.Context, modeID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.modes[modeID]; !ok {
+		return ErrModeNotFound
+	}
+	for _, t := range r.m.trips {
+		if t.ModeID == modeID {
+			return ErrModeInUse
+		}
+	}
+	for _, u := range r.m.users {
+		if u.BaselineModeID != nil && *u.BaselineModeID == modeID {
+			return ErrModeInUse
+		}
+	}
+	// the default modes are reset, as by the foreign key
+	for id, u := range r.m.users {
+		if u.DefaultModeID != nil && *u.DefaultModeID == modeID {
+			u.DefaultModeID = nil
+			r.m.users[id] = u
+		}
+	}
+	delete(r.m.modes, modeID)
+	return nil
+}
+
+type memoryBudgetRepository struct{ m *memoryDB }
+
+func (r *memoryBudgetRepository) GetByUser(ctx context.Context, userID int) (*models.CarbonBudget, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	budget, ok := r.m.budgets[userID]
+	if !ok {
+		return nil, ErrBudgetNotFound
+	}
+	return &budget, nil
+}
+
+func (r *memoryBudgetRepository) GetByUserForUpdate(ctx context.Context, userID int) (*models.CarbonBudget, error) {
+	return r.GetByUser(ctx, userID)
+}
+
+func (r *memoryBudgetRepository) Upsert(ctx context.Context, userID int, period string, limitKg float64) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	now := time.Now()
+	budget, ok := r.m.budgets[userID]
+	if !ok {
+		budget = models.CarbonBudget{BudgetID: r.m.nextID(), UserID: userID, CreatedAt: now}
+	}
+	budget.Period = period
+	budget.LimitKg = limitKg
+	budget.LastAlertThreshold = 0
+	budget.AlertPeriodStart = nil
+	budget.UpdatedAt = now
+	r.m.budgets[userID] = budget
+	return nil
+}
+
+func (r *memoryBudgetRepository) UpdateAlertState(ctx context.Context, budgetID, threshold int, periodStart time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for userID, budget := range r.m.budgets {
+		if budget.BudgetID == budgetID {
+			budget.LastAlertThreshold = threshold
+			budget.AlertPeriodStart = &periodStart
+			r.m.budgets[userID] = budget
+		}
+	}
+	return nil
+}
+
+func (r *memoryBudgetRepository) DeleteByUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delete(r.m.budgets, userID)
+	return nil
+}
+
+type memoryNotificationRepository struct{ m *memoryDB }
+
+func (r *memoryNotificationRepository) Create(ctx context.Context, userID int, notificationType, message string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	id := r.m.nextID()
+	r.m.notifications[id] = models.Notification{
+		NotificationID: id,
+		UserID:         userID,
+		Type:           notificationType,
+		Message:        message,
+		CreatedAt:      time.Now(),
+	}
+	return nil
+}
+
+func (r *memoryNotificationRepository) GetByUser(ctx context.Context, userID int) ([]models.Notification, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	notifications := []models.Notification{}
+	for _, n := range r.m.notifications {
+		if n.UserID == userID {
+			notifications = append(notifications, n)
+		}
+	}
+	// latest first, like the Postgres implementation
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].NotificationID > notifications[j].NotificationID
+	})
+	return notifications, nil
+}
+
+func (r *memoryNotificationRepository) MarkRead(ctx context.Context, userID, notificationID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	n, ok := r.m.notifications[notificationID]
+	if !ok || n.UserID != userID {
+		return ErrNotificationNotFound
+	}
+	now := time.Now()
+	n.ReadAt = &now
+	r.m.notifications[notificationID] = n
+	return nil
+}
+
+func (r *memoryNotificationRepository) DeleteByUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for id, n := range r.m.notifications {
+		if n.UserID == userID {
+			delete(r.m.notifications, id)
+		}
+	}
+	return nil
+}
+
+type memoryChallengeRepository struct{ m *memoryDB }
+
+func (r *memoryChallengeRepository) Create(ctx context.Context, challenge *models.Challenge) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	challenge.ChallengeID = r.m.nextID()
+	challenge.CreatedAt = time.Now()
+	r.m.challenges[challenge.ChallengeID] = *challenge
+	return nil
+}
+
+func (r *memoryChallengeRepository) GetByID(ctx context.Context, challengeID int) (*models.Challenge, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	challenge, ok := r.m.challenges[challengeID]
+	if !ok {
+		return nil, ErrChallengeNotFound
+	}
+	return &challenge, nil
+}
+
+func (r *memoryChallengeRepository) GetAll(ctx context.Context) ([]models.Challenge, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	challenges := []models.Challenge{}
+	for _, c := range r.m.challenges {
+		challenges = append(challenges, c)
+	}
+	// latest first, like the Postgres implementation
+	sort.Slice(challenges, func(i, j int) bool {
+		if !challenges[i].StartDate.Equal(challenges[j].StartDate) {
+			return challenges[i].StartDate.After(challenges[j].StartDate)
+		}
+		return challenges[i].ChallengeID < challenges[j].ChallengeID
+	})
+	return challenges, nil
+}
+
+func (r *memoryChallengeRepository) Update(ctx context.Context, challenge models.Challenge) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	existing, ok := r.m.challenges[challenge.ChallengeID]
+	if !ok {
+		return ErrChallengeNotFound
+	}
+	challenge.CreatedAt = existing.CreatedAt
+	r.m.challenges[challenge.ChallengeID] = challenge
+	return nil
+}
+
+func (r *memoryChallengeRepository) Delete(ctx context.Context, challengeID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.challenges[challengeID]; !ok {
+		return ErrChallengeNotFound
+	}
+	delete(r.m.challenges, challengeID)
+	return nil
+}
+
+type memoryAuditRepository struct{ m *memoryDB }
+
+func (r *memoryAuditRepository) Create(ctx context.Context, entry models.AuditEntry) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	entry.AuditID = len(r.m.audit) + 1
+	entry.CreatedAt = time.Now()
+	r.m.audit = append(r.m.audit, entry)
+	return nil
+}
+
+func (r *memoryAuditRepository) List(ctx context.Context, limit, offset int) ([]models.AuditEntry, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	entries := []models.AuditEntry{}
+	for i := len(r.m.audit) - 1 - offset; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, r.m.audit[i])
+	}
+	return entries, nil
+}
+
+func (r *memoryAuditRepository) AnonymizeUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for i, entry := range r.m.audit {
+		if entry.ActorID != nil && *entry.ActorID == userID {
+			r.m.audit[i].ActorID = nil
+		}
+		if entry.TargetType == "user" && entry.TargetID == userID && entry.Details["email"] != nil {
+			details := make(map[string]interface{}, len(entry.Details))
+			for key, value := range entry.Details {
+				if key != "email" {
+					details[key] = value
+				}
+			}
+			r.m.audit[i].Details = details
+		}
+	}
+	return nil
+}
+
+type memoryParticipationRepository struct{ m *memoryDB }
+
+func (r *memoryParticipationRepository) GetByUser(ctx context.Context, userID int) ([]models.ChallengeParticipation, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	participations := []models.ChallengeParticipation{}
+	for _, p := range r.m.participations {
+		if p.UserID == userID {
+			participations = append(participations, p)
+		}
+	}
+	return participations, nil
+}
+
+func (r *memoryParticipationRepository) DeleteByUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.participations = slices.DeleteFunc(r.m.participations, func(p models.ChallengeParticipation) bool { return p.UserID == userID })
+	return nil
+}
+
+type memoryRecommendationRepository struct{ m *memoryDB }
+
+func (r *memoryRecommendationRepository) GetByUser(ctx context.Context, userID int) ([]models.Recommendation, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	recommendations := []models.Recommendation{}
+	for _, rec := range r.m.recommendations {
+		if rec.UserID == userID {
+			recommendations = append(recommendations, rec)
+		}
+	}
+	return recommendations, nil
+}
+
+func (r *memoryRecommendationRepository) DeleteByUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.recommendations = slices.DeleteFunc(r.m.recommendations, func(rec models.Recommendation) bool { return rec.UserID == userID })
+	return nil
+}
+
+type memoryAccountDeletionRepository struct{ m *memoryDB }
+
+func (r *memoryAccountDeletionRepository) Upsert(ctx context.Context, deletion models.AccountDeletion) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if existing, ok := r.m.deletions[deletion.UserID]; ok {
+		deletion.CreatedAt = existing.CreatedAt
+	} else {
+		deletion.CreatedAt = time.Now()
+	}
+	r.m.deletions[deletion.UserID] = deletion
+	return nil
+}
+
+func (r *memoryAccountDeletionRepository) GetByUser(ctx context.Context, userID int) (*models.AccountDeletion, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	deletion, ok := r.m.deletions[userID]
+	if !ok {
+		return nil, ErrDeletionNotFound
+	}
+	return &deletion, nil
+}
+
+func (r *memoryAccountDeletionRepository) GetByUserForUpdate(ctx context.Context, userID int) (*models.AccountDeletion, error) {
+	return r.GetByUser(ctx, userID)
+}
+
+func (r *memoryAccountDeletionRepository) Due(ctx context.Context, now time.Time) ([]models.AccountDeletion, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	deletions := []models.AccountDeletion{}
+	for _, deletion := range r.m.deletions {
+		if deletion.ScheduledAt != nil && !deletion.ScheduledAt.After(now) {
+			deletions = append(deletions, deletion)
+		}
+	}
+	sort.Slice(deletions, func(i, j int) bool { return deletions[i].ScheduledAt.Before(*deletions[j].ScheduledAt) })
+	return deletions, nil
+}
+
+func (r *memoryAccountDeletionRepository) DeleteByUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delete(r.m.deletions, userID)
+	return nil
+}
+
+type memoryReportKey struct {
+	userID int
+	period string
+}
+
+type memoryReportJobRepository struct{ m *memoryDB }
+
+func (r *memoryReportJobRepository) Start(ctx context.Context, job models.ReportJob, staleBefore time.Time) (*models.ReportJob, bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	key := memoryReportKey{job.UserID, job.Period}
+	if existing, ok := r.m.reportJobs[key]; ok && existing.Status == job.Status && !existing.RequestedAt.Before(staleBefore) {
+		return &existing, false, nil
+	}
+	r.m.reportJobs[key] = job
+	return &job, true, nil
+}
+
+func (r *memoryReportJobRepository) Finish(ctx context.Context, job models.ReportJob) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	key := memoryReportKey{job.UserID, job.Period}
+	if existing, ok := r.m.reportJobs[key]; ok && existing.RequestedAt.Equal(job.RequestedAt) {
+		r.m.reportJobs[key] = job
+	}
+	return nil
+}
+
+func (r *memoryReportJobRepository) Get(ctx context.Context, userID int, period string) (*models.ReportJob, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	job, ok := r.m.reportJobs[memoryReportKey{userID, period}]
+	if !ok {
+		return nil, ErrReportNotFound
+	}
+	return &job, nil
+}
+
+func (r *memoryReportJobRepository) GetByUser(ctx context.Context, userID int) ([]models.ReportJob, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	jobs := []models.ReportJob{}
+	for key, job := range r.m.reportJobs {
+		if key.userID == userID {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Period < jobs[j].Period })
+	return jobs, nil
+}
+
+func (r *memoryReportJobRepository) DeleteByUser(ctx context.Context, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for key := range r.m.reportJobs {
+		if key.userID == userID {
+			delete(r.m.reportJobs, key)
+		}
+	}
+	return nil
+}
+
+// NewMemoryLimits returns rate limit and login attempt repositories keeping
+// their state in memory, for a single instance
+func NewMemoryLimits() (RateLimitRepository, LoginAttemptRepository) {
+	return &memoryRateLimitRepository{buckets: make(map[string]*memoryBucket)},
+		&memoryLoginAttemptRepository{attempts: make(map[string]*memoryLoginAttempt)}
+}
+
+type memoryBucket struct {
+	tokens    float64
+	rate      float64
+	burst     float64
+	updatedAt time.Time
+}
+
+// refill adds the tokens earned since the last update
+func (b *memoryBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.updatedAt).Seconds()*b.rate)
+	b.updatedAt = now
+}
+
+type memoryRateLimitRepository struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+func (r *memoryRateLimitRepository) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(burst), updatedAt: now}
+		r.buckets[key] = b
+	}
+	b.rate, b.burst = rate, float64(burst)
+	b.refill(now)
+	if b.tokens < 1 {
+		return retryAfter(b.tokens, rate), nil
+	}
+	b.tokens--
+	return 0, nil
+}
+
+func (r *memoryRateLimitRepository) Prune(ctx context.Context, idle time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var pruned int
+	for key, b := range r.buckets {
+		if time.Since(b.updatedAt) > idle {
+			delete(r.buckets, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+type memoryLoginAttempt struct {
+	failures    int
+	lockedUntil time.Time
+	updatedAt   time.Time
+}
+
+type memoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]*memoryLoginAttempt
+}
+
+func (r *memoryLoginAttemptRepository) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a, ok := r.attempts[key]; ok {
+		return a.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (r *memoryLoginAttemptRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+
+	a, ok := r.attempts[key]
+	if !ok {
+		a = &memoryLoginAttempt{}
+		r.attempts[key] = a
+	}
+	if now.Sub(a.updatedAt) > window {
+		a.failures = 0
+	}
+	a.failures++
+	a.updatedAt = now
+	return a.failures, nil
+}
+
+func (r *memoryLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a, ok := r.attempts[key]; ok {
+		a.lockedUntil = until
+	}
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, key)
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) ResetPrefix(ctx context.Context, prefix string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.attempts {
+		if strings.HasPrefix(key, prefix) {
+			delete(r.attempts, key)
+		}
+	}
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) Prune(ctx context.Context, window time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var pruned int
+	for key, a := range r.attempts {
+		if now.Sub(a.updatedAt) > window && now.After(a.lockedUntil) {
+			delete(r.attempts, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolConnsDesc = prometheus.NewDesc("db_pool_connections",
+		"Connections of the pool, by state (acquired, idle or constructing).", []string{"state"}, nil)
+	poolMaxConnsDesc = prometheus.NewDesc("db_pool_max_connections",
+		"Maximum size of the pool.", nil, nil)
+	poolAcquiresDesc = prometheus.NewDesc("db_pool_acquires_total",
+		"Connections acquired from the pool.", nil, nil)
+	poolEmptyAcquiresDesc = prometheus.NewDesc("db_pool_empty_acquires_total",
+		"Acquires that waited for a connection because the pool was empty.", nil, nil)
+	poolCanceledAcquiresDesc = prometheus.NewDesc("db_pool_canceled_acquires_total",
+		"Acquires canceled before getting a connection.", nil, nil)
+	poolAcquireDurationDesc = prometheus.NewDesc("db_pool_acquire_duration_seconds_total",
+		"Time spent acquiring connections.", nil, nil)
+)
+
+// poolCollector reports the statistics of the connection pool when scraped
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+// Collector returns the Prometheus collector of the connection pool statistics
+func (db *Database) Collector() prometheus.Collector {
+	return poolCollector{pool: db.Pool}
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConnsDesc
+	ch <- poolMaxConnsDesc
+	ch <- poolAcquiresDesc
+	ch <- poolEmptyAcquiresDesc
+	ch <- poolCanceledAcquiresDesc
+	ch <- poolAcquireDurationDesc
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(poolConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(poolConnsDesc, prometheus.GaugeValue, float64(stat.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(poolMaxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquiresDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceledAcquiresDesc, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDurationDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS recommendations;
+DROP TABLE IF EXISTS challengeparticipation;
+DROP TABLE IF EXISTS challenges;
+DROP TABLE IF EXISTS trips;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS transportationmodes;
@@ -0,0 +1,118 @@
+-- Tables described in models.go. IF NOT EXISTS lets databases that were
+-- created by hand adopt the migrations, the constraints such a table may lack
+-- are added at the end.
+
+CREATE TABLE IF NOT EXISTS transportationmodes (
+    mode_id     INTEGER PRIMARY KEY,
+    mode_name   TEXT NOT NULL,
+    description TEXT
+);
+
+-- the Impact CO₂ transports, under their ID there, described by 0006
+INSERT INTO transportationmodes (mode_id, mode_name) VALUES
+    (1, 'Avion'),
+    (2, 'TGV'),
+    (3, 'Intercités'),
+    (4, 'Voiture thermique'),
+    (5, 'Voiture électrique'),
+    (6, 'Autocar'),
+    (7, 'Vélo'),
+    (8, 'Vélo à assistance électrique'),
+    (9, 'Bus thermique'),
+    (10, 'Tramway'),
+    (11, 'Métro'),
+    (12, 'Scooter'),
+    (13, 'Moto'),
+    (14, 'RER ou Transilien'),
+    (15, 'TER'),
+    (16, 'Bus électrique'),
+    (17, 'Trottinette électrique'),
+    (21, 'Bus GNV'),
+    (30, 'Marche')
+ON CONFLICT (mode_id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS users (
+    user_id       SERIAL PRIMARY KEY,
+    email         TEXT NOT NULL CONSTRAINT users_email_key UNIQUE,
+    username      TEXT NOT NULL CONSTRAINT users_username_key UNIQUE,
+    password_hash TEXT NOT NULL,
+    google_id     TEXT CONSTRAINT users_google_id_key UNIQUE,
+    github_id     TEXT CONSTRAINT users_github_id_key UNIQUE,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS trips (
+    trip_id          SERIAL PRIMARY KEY,
+    user_id          INTEGER NOT NULL CONSTRAINT trips_user_id_fkey REFERENCES users (user_id) ON DELETE CASCADE,
+    start_address    TEXT,
+    end_address      TEXT,
+    distance_km      DOUBLE PRECISION CONSTRAINT trips_distance_km_check CHECK (distance_km >= 0),
+    mode_id          INTEGER NOT NULL CONSTRAINT trips_mode_id_fkey REFERENCES transportationmodes (mode_id),
+    carbon_impact_kg DOUBLE PRECISION CONSTRAINT trips_carbon_impact_kg_check CHECK (carbon_impact_kg >= 0),
+    trip_date        TIMESTAMPTZ NOT NULL,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS trips_user_id_idx ON trips (user_id);
+CREATE INDEX IF NOT EXISTS trips_trip_date_idx ON trips (trip_date);
+
+CREATE TABLE IF NOT EXISTS challenges (
+    challenge_id SERIAL PRIMARY KEY,
+    name         TEXT NOT NULL,
+    description  TEXT,
+    start_date   TIMESTAMPTZ NOT NULL,
+    end_date     TIMESTAMPTZ NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT challenges_check CHECK (end_date >= start_date)
+);
+
+CREATE TABLE IF NOT EXISTS challengeparticipation (
+    participation_id SERIAL PRIMARY KEY,
+    user_id          INTEGER NOT NULL CONSTRAINT challengeparticipation_user_id_fkey REFERENCES users (user_id) ON DELETE CASCADE,
+    challenge_id     INTEGER NOT NULL CONSTRAINT challengeparticipation_challenge_id_fkey REFERENCES challenges (challenge_id) ON DELETE CASCADE,
+    progress         DOUBLE PRECISION,
+    completed        BOOLEAN NOT NULL DEFAULT FALSE,
+    CONSTRAINT challengeparticipation_user_id_challenge_id_key UNIQUE (user_id, challenge_id)
+);
+
+CREATE INDEX IF NOT EXISTS challengeparticipation_challenge_id_idx ON challengeparticipation (challenge_id);
+
+CREATE TABLE IF NOT EXISTS recommendations (
+    recommendation_id SERIAL PRIMARY KEY,
+    user_id           INTEGER NOT NULL CONSTRAINT recommendations_user_id_fkey REFERENCES users (user_id) ON DELETE CASCADE,
+    message           TEXT NOT NULL,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS recommendations_user_id_idx ON recommendations (user_id);
+
+-- CREATE TABLE IF NOT EXISTS keeps the tables created by hand as they are, add
+-- the constraints they lack. NOT VALID spares the rows already there, the new
+-- ones are checked, the duplicates of a
+-- unique column have to be removed first.
+DO $$
+DECLARE
+    c RECORD;
+BEGIN
+    FOR c IN SELECT * FROM (VALUES
+        ('users', 'users_email_key', 'UNIQUE (email)'),
+        ('users', 'users_username_key', 'UNIQUE (username)'),
+        ('users', 'users_google_id_key', 'UNIQUE (google_id)'),
+        ('users', 'users_github_id_key', 'UNIQUE (github_id)'),
+        ('trips', 'trips_user_id_fkey', 'FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE NOT VALID'),
+        ('trips', 'trips_mode_id_fkey', 'FOREIGN KEY (mode_id) REFERENCES transportationmodes (mode_id) NOT VALID'),
+        ('trips', 'trips_distance_km_check', 'CHECK (distance_km >= 0) NOT VALID'),
+        ('trips', 'trips_carbon_impact_kg_check', 'CHECK (carbon_impact_kg >= 0) NOT VALID'),
+        ('challenges', 'challenges_check', 'CHECK (end_date >= start_date) NOT VALID'),
+        ('challengeparticipation', 'challengeparticipation_user_id_fkey', 'FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE NOT VALID'),
+        ('challengeparticipation', 'challengeparticipation_challenge_id_fkey', 'FOREIGN KEY (challenge_id) REFERENCES challenges (challenge_id) ON DELETE CASCADE NOT VALID'),
+        ('challengeparticipation', 'challengeparticipation_user_id_challenge_id_key', 'UNIQUE (user_id, challenge_id)'),
+        ('recommendations', 'recommendations_user_id_fkey', 'FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE NOT VALID')
+    ) AS constraints (table_name, constraint_name, definition)
+    LOOP
+        IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = c.table_name::regclass AND conname = c.constraint_name) THEN
+            EXECUTE format('ALTER TABLE %I ADD CONSTRAINT %I %s', c.table_name, c.constraint_name, c.definition);
+        END IF;
+    END LOOP;
+END $$;
@@ -0,0 +1,2 @@
+ALTER TABLE trips DROP COLUMN IF EXISTS baseline_impact_kg;
+ALTER TABLE users DROP COLUMN IF EXISTS baseline_mode_id;
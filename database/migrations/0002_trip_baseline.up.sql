@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS baseline_mode_id INTEGER REFERENCES transportationmodes (mode_id);
+ALTER TABLE trips ADD COLUMN IF NOT EXISTS baseline_impact_kg DOUBLE PRECISION CHECK (baseline_impact_kg >= 0);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS carbon_budgets (
+    budget_id            SERIAL PRIMARY KEY,
+    user_id              INTEGER NOT NULL UNIQUE REFERENCES users (user_id) ON DELETE CASCADE,
+    period               TEXT NOT NULL CHECK (period IN ('monthly', 'yearly')),
+    limit_kg             DOUBLE PRECISION NOT NULL CHECK (limit_kg > 0),
+    last_alert_threshold INTEGER NOT NULL DEFAULT 0,
+    alert_period_start   TIMESTAMPTZ,
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at           TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    notification_id SERIAL PRIMARY KEY,
+    user_id         INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    type            TEXT NOT NULL,
+    message         TEXT NOT NULL,
+    read_at         TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_idx ON notifications (user_id, created_at);
@@ -0,0 +1,10 @@
+ALTER TABLE transportationmodes
+    DROP COLUMN IF EXISTS names,
+    DROP COLUMN IF EXISTS enabled,
+    DROP COLUMN IF EXISTS impact_co2_id,
+    DROP COLUMN IF EXISTS emission_factor_kg_per_km,
+    DROP COLUMN IF EXISTS icon,
+    DROP COLUMN IF EXISTS category;
+
+ALTER TABLE transportationmodes ALTER COLUMN mode_id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS transportationmodes_mode_id_seq;
@@ -0,0 +1,43 @@
+-- the mode IDs were the Impact CO₂ transport IDs so far, new modes get theirs
+-- from a sequence and are mapped to the provider by impact_co2_id
+CREATE SEQUENCE IF NOT EXISTS transportationmodes_mode_id_seq OWNED BY transportationmodes.mode_id;
+SELECT setval('transportationmodes_mode_id_seq', COALESCE(MAX(mode_id), 0) + 1, false) FROM transportationmodes;
+ALTER TABLE transportationmodes ALTER COLUMN mode_id SET DEFAULT nextval('transportationmodes_mode_id_seq');
+
+ALTER TABLE transportationmodes
+    ADD COLUMN IF NOT EXISTS category TEXT NOT NULL DEFAULT 'private' CHECK (category IN ('active', 'public', 'private', 'air')),
+    ADD COLUMN IF NOT EXISTS icon TEXT,
+    ADD COLUMN IF NOT EXISTS emission_factor_kg_per_km DOUBLE PRECISION CHECK (emission_factor_kg_per_km >= 0),
+    ADD COLUMN IF NOT EXISTS impact_co2_id INTEGER,
+    ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT true,
+    ADD COLUMN IF NOT EXISTS names JSONB NOT NULL DEFAULT '{}';
+
+UPDATE transportationmodes SET impact_co2_id = mode_id;
+
+-- metadata of the Impact CO₂ transports
+UPDATE transportationmodes m
+SET category = known.category,
+    icon     = known.icon,
+    names    = known.names::jsonb
+FROM (VALUES
+    (1, 'air', 'plane', '{"fr": "Avion", "en": "Plane"}'),
+    (2, 'public', 'train', '{"fr": "TGV", "en": "High-speed train"}'),
+    (3, 'public', 'train', '{"fr": "Intercités", "en": "Intercity train"}'),
+    (4, 'private', 'car', '{"fr": "Voiture thermique", "en": "Petrol car"}'),
+    (5, 'private', 'car', '{"fr": "Voiture électrique", "en": "Electric car"}'),
+    (6, 'public', 'bus', '{"fr": "Autocar", "en": "Coach"}'),
+    (7, 'active', 'bike', '{"fr": "Vélo", "en": "Bike"}'),
+    (8, 'active', 'bike', '{"fr": "Vélo à assistance électrique", "en": "E-bike"}'),
+    (9, 'public', 'bus', '{"fr": "Bus thermique", "en": "Bus"}'),
+    (10, 'public', 'tram', '{"fr": "Tramway", "en": "Tramway"}'),
+    (11, 'public', 'subway', '{"fr": "Métro", "en": "Metro"}'),
+    (12, 'private', 'scooter', '{"fr": "Scooter", "en": "Scooter"}'),
+    (13, 'private', 'motorbike', '{"fr": "Moto", "en": "Motorbike"}'),
+    (14, 'public', 'train', '{"fr": "RER ou Transilien", "en": "Suburban train"}'),
+    (15, 'public', 'train', '{"fr": "TER", "en": "Regional train"}'),
+    (16, 'public', 'bus', '{"fr": "Bus électrique", "en": "Electric bus"}'),
+    (17, 'active', 'kick-scooter', '{"fr": "Trottinette électrique", "en": "E-scooter"}'),
+    (21, 'public', 'bus', '{"fr": "Bus GNV", "en": "CNG bus"}'),
+    (30, 'active', 'walk', '{"fr": "Marche", "en": "Walking"}')
+) AS known (mode_id, category, icon, names)
+WHERE m.mode_id = known.mode_id;
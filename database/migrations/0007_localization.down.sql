@@ -0,0 +1,3 @@
+ALTER TABLE transportationmodes DROP COLUMN IF EXISTS descriptions;
+
+ALTER TABLE users DROP COLUMN IF EXISTS language;
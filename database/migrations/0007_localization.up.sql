@@ -0,0 +1,4 @@
+-- NULL follows the Accept-Language header of the requests
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language TEXT;
+
+ALTER TABLE transportationmodes ADD COLUMN IF NOT EXISTS descriptions JSONB NOT NULL DEFAULT '{}';
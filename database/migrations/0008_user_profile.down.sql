@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS email_changes;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS avatar_key,
+    DROP COLUMN IF EXISTS default_mode_id,
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS units;
@@ -0,0 +1,15 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS units TEXT NOT NULL DEFAULT 'metric' CHECK (units IN ('metric', 'imperial')),
+    ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC',
+    ADD COLUMN IF NOT EXISTS default_mode_id INTEGER REFERENCES transportationmodes (mode_id) ON DELETE SET NULL,
+    -- key of the avatar in the blob storage
+    ADD COLUMN IF NOT EXISTS avatar_key TEXT;
+
+-- the email a user changes to, until the token sent to it is confirmed
+CREATE TABLE IF NOT EXISTS email_changes (
+    user_id    INTEGER PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+    email      TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
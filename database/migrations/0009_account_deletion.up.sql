@@ -0,0 +1,11 @@
+-- the deletions asked by the users, scheduled once confirmed with the token
+-- sent to their email and run after the grace period
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id          INTEGER PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+    token_hash       TEXT NOT NULL,
+    token_expires_at TIMESTAMPTZ NOT NULL,
+    scheduled_at     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS account_deletions_scheduled_at_idx ON account_deletions (scheduled_at);
@@ -0,0 +1,12 @@
+-- the reports asked by the users, rendered to the blob storage so that every
+-- instance serves them
+CREATE TABLE IF NOT EXISTS report_jobs (
+    user_id      INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    period       TEXT NOT NULL,
+    language     TEXT NOT NULL,
+    status       TEXT NOT NULL CHECK (status IN ('pending', 'ready', 'failed')),
+    error        TEXT,
+    requested_at TIMESTAMPTZ NOT NULL,
+    generated_at TIMESTAMPTZ,
+    PRIMARY KEY (user_id, period)
+);
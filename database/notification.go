@@ -0,0 +1,73 @@
+package database
+
+import (
+	"API/models"
+	"context"
+	"fmt"
+	"time"
+)
+
+const NotificationTypeBudgetThreshold = "budget_threshold"
+
+type postgresNotificationRepository struct {
+	db querier
+}
+
+func (r *postgresNotificationRepository) Create(ctx context.Context, userID int, notificationType, message string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO notifications (user_id, type, message, created_at) VALUES ($1, $2, $3, $4)`
+	_, err := r.db.Exec(ctx, query, userID, notificationType, message, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresNotificationRepository) GetByUser(ctx context.Context, userID int) ([]models.Notification, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT notification_id, user_id, type, message, read_at, created_at FROM notifications
+		WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications: %w", err)
+	}
+	defer rows.Close()
+	notifications := []models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.NotificationID, &n.UserID, &n.Type, &n.Message, &n.ReadAt, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to get notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+func (r *postgresNotificationRepository) MarkRead(ctx context.Context, userID, notificationID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE notifications SET read_at = $1 WHERE notification_id = $2 AND user_id = $3`
+	res, err := r.db.Exec(ctx, query, time.Now(), notificationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update notification: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+func (r *postgresNotificationRepository) DeleteByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM notifications WHERE user_id = $1`
+	if _, err := r.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete notifications: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type postgresRateLimitRepository struct {
+	db querier
+}
+
+// Take refills and takes from the bucket in a single statement, so that the
+// instances sharing the table don't race
+func (r *postgresRateLimitRepository) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO rate_limit_buckets AS b (bucket_key, tokens, allowed, updated_at)
+		VALUES ($1, $2::float8 - 1, true, now())
+		ON CONFLICT (bucket_key) DO UPDATE SET
+			tokens = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8)
+				- CASE WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) >= 1 THEN 1 ELSE 0 END,
+			allowed = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) >= 1,
+			updated_at = now()
+		RETURNING tokens, allowed`
+	var tokens float64
+	var allowed bool
+	if err := r.db.QueryRow(ctx, query, key, float64(burst), rate).Scan(&tokens, &allowed); err != nil {
+		return 0, fmt.Errorf("failed to take a rate limit token: %w", err)
+	}
+	if allowed {
+		return 0, nil
+	}
+	return retryAfter(tokens, rate), nil
+}
+
+func (r *postgresRateLimitRepository) Prune(ctx context.Context, idle time.Duration) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM rate_limit_buckets WHERE updated_at < now() - $1::float8 * interval '1 second'`
+	tag, err := r.db.Exec(ctx, query, idle.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune the rate limit buckets: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// retryAfter returns the time until the bucket holds a token again
+func retryAfter(tokens, rate float64) time.Duration {
+	return time.Duration((1 - tokens) / rate * float64(time.Second))
+}
+
+type postgresLoginAttemptRepository struct {
+	db querier
+}
+
+func (r *postgresLoginAttemptRepository) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT locked_until FROM login_attempts WHERE login_key = $1`
+	var lockedUntil *time.Time
+	if err := r.db.QueryRow(ctx, query, key).Scan(&lockedUntil); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get login attempts: %w", err)
+	}
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+	return *lockedUntil, nil
+}
+
+func (r *postgresLoginAttemptRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO login_attempts AS a (login_key, failures, updated_at) VALUES ($1, 1, now())
+		ON CONFLICT (login_key) DO UPDATE SET
+			failures = CASE WHEN a.updated_at < now() - $2::float8 * interval '1 second' THEN 1 ELSE a.failures + 1 END,
+			updated_at = now()
+		RETURNING failures`
+	var failures int
+	if err := r.db.QueryRow(ctx, query, key, window.Seconds()).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to record the failed login: %w", err)
+	}
+	return failures, nil
+}
+
+func (r *postgresLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE login_attempts SET locked_until = $2 WHERE login_key = $1`
+	if _, err := r.db.Exec(ctx, query, key, until); err != nil {
+		return fmt.Errorf("failed to lock the login: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresLoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM login_attempts WHERE login_key = $1`
+	if _, err := r.db.Exec(ctx, query, key); err != nil {
+		return fmt.Errorf("failed to reset the login attempts: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresLoginAttemptRepository) ResetPrefix(ctx context.Context, prefix string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM login_attempts WHERE starts_with(login_key, $1)`
+	if _, err := r.db.Exec(ctx, query, prefix); err != nil {
+		return fmt.Errorf("failed to reset the login attempts: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresLoginAttemptRepository) Prune(ctx context.Context, window time.Duration) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM login_attempts WHERE updated_at < now() - $1::float8 * interval '1 second'
+		AND (locked_until IS NULL OR locked_until < now())`
+	tag, err := r.db.Exec(ctx, query, window.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune the login attempts: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
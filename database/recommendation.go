@@ -0,0 +1,45 @@
+package database
+
+import (
+	"API/models"
+	"context"
+	"fmt"
+)
+
+type postgresRecommendationRepository struct {
+	db querier
+}
+
+func (r *postgresRecommendationRepository) GetByUser(ctx context.Context, userID int) ([]models.Recommendation, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT recommendation_id, user_id, message, created_at FROM recommendations
+		WHERE user_id = $1 ORDER BY recommendation_id`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recommendations: %w", err)
+	}
+	defer rows.Close()
+	recommendations := []models.Recommendation{}
+	for rows.Next() {
+		var rec models.Recommendation
+		if err := rows.Scan(&rec.RecommendationID, &rec.UserID, &rec.Message, &rec.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to get recommendation: %w", err)
+		}
+		recommendations = append(recommendations, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get recommendations: %w", err)
+	}
+	return recommendations, nil
+}
+
+func (r *postgresRecommendationRepository) DeleteByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM recommendations WHERE user_id = $1`
+	if _, err := r.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete recommendations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"API/models"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type postgresReportJobRepository struct {
+	db querier
+}
+
+const reportJobColumns = `user_id, period, language, status, COALESCE(error, ''), requested_at, generated_at`
+
+func scanReportJob(row pgx.Row) (models.ReportJob, error) {
+	var job models.ReportJob
+	err := row.Scan(&job.UserID, &job.Period, &job.Language, &job.Status, &job.Error, &job.RequestedAt, &job.GeneratedAt)
+	return job, err
+}
+
+func (r *postgresReportJobRepository) Start(ctx context.Context, job models.ReportJob, staleBefore time.Time) (*models.ReportJob, bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO report_jobs (user_id, period, language, status, error, requested_at, generated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+		ON CONFLICT (user_id, period) DO UPDATE SET language = EXCLUDED.language, status = EXCLUDED.status,
+			error = EXCLUDED.error, requested_at = EXCLUDED.requested_at, generated_at = EXCLUDED.generated_at
+		WHERE report_jobs.status <> EXCLUDED.status OR report_jobs.requested_at < $8
+		RETURNING ` + reportJobColumns
+	started, err := scanReportJob(r.db.QueryRow(ctx, query, job.UserID, job.Period, job.Language, job.Status, job.Error,
+		job.RequestedAt, job.GeneratedAt, staleBefore))
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the job already there is kept
+		existing, err := r.Get(ctx, job.UserID, job.Period)
+		return existing, false, err
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to start report job: %w", err)
+	}
+	return &started, true, nil
+}
+
+func (r *postgresReportJobRepository) Finish(ctx context.Context, job models.ReportJob) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE report_jobs SET status = $1, error = NULLIF($2, ''), generated_at = $3
+		WHERE user_id = $4 AND period = $5 AND requested_at = $6`
+	_, err := r.db.Exec(ctx, query, job.Status, job.Error, job.GeneratedAt, job.UserID, job.Period, job.RequestedAt)
+	if err != nil {
+		return fmt.Errorf("failed to finish report job: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresReportJobRepository) Get(ctx context.Context, userID int, period string) (*models.ReportJob, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + reportJobColumns + ` FROM report_jobs WHERE user_id = $1 AND period = $2`
+	job, err := scanReportJob(r.db.QueryRow(ctx, query, userID, period))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrReportNotFound
+		}
+		return nil, fmt.Errorf("failed to get report job: %w", err)
+	}
+	return &job, nil
+}
+
+func (r *postgresReportJobRepository) GetByUser(ctx context.Context, userID int) ([]models.ReportJob, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + reportJobColumns + ` FROM report_jobs WHERE user_id = $1 ORDER BY period`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report jobs: %w", err)
+	}
+	defer rows.Close()
+	jobs := []models.ReportJob{}
+	for rows.Next() {
+		job, err := scanReportJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get report job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get report jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func (r *postgresReportJobRepository) DeleteByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM report_jobs WHERE user_id = $1`
+	if _, err := r.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete report jobs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"API/models"
+	"API/utils"
+	"context"
+	"time"
+)
+
+// UserRepository stores the Users table
+type UserRepository interface {
+	Create(ctx context.Context, user models.User) (int, error)
+	GetByID(ctx context.Context, userID int) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	GetAll(ctx context.Context) ([]models.User, error)
+	// Search returns a page of the users matching the filter, by ID, and the
+	// number of users matching it
+	Search(ctx context.Context, filter UserFilter) ([]models.User, int, error)
+	Update(ctx context.Context, user models.User) error
+	UpdateBaselineMode(ctx context.Context, userID, modeID int) error
+	UpdateRole(ctx context.Context, userID int, role string) error
+	// UpdateLanguage sets the language of the user, nil follows the requests
+	UpdateLanguage(ctx context.Context, userID int, language *string) error
+	UpdatePreferences(ctx context.Context, userID int, preferences models.Preferences) error
+	// UpdateAvatar sets the key of the avatar of the user, nil removes it
+	UpdateAvatar(ctx context.Context, userID int, avatarKey *string) error
+	// SetDisabled disables the account of the user, or enables it again
+	SetDisabled(ctx context.Context, userID int, disabled bool) error
+	Delete(ctx context.Context, userID int) error
+}
+
+// UserFilter selects the users listed by UserRepository.Search
+type UserFilter struct {
+	// Query matches a part of the email or of the username, ignoring case
+	Query string
+	Role  string
+	// Disabled selects the disabled or the enabled accounts when set
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+// EmailChangeRepository stores the EmailChanges table, at most one pending
+// change by user
+type EmailChangeRepository interface {
+	// Upsert creates or replaces the pending change of the user
+	Upsert(ctx context.Context, change models.EmailChange) error
+	GetByUser(ctx context.Context, userID int) (*models.EmailChange, error)
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// TripRepository stores the Trips table
+type TripRepository interface {
+	Create(ctx context.Context, trip *models.Trip) error
+	GetByID(ctx context.Context, tripID int) (*models.Trip, error)
+	GetByUser(ctx context.Context, userID int) ([]models.Trip, error)
+	Update(ctx context.Context, trip *models.Trip) error
+	Delete(ctx context.Context, tripID int) error
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// TransportationModeRepository stores the TransportationModes table
+type TransportationModeRepository interface {
+	GetByID(ctx context.Context, modeID int) (*models.TransportationMode, error)
+	GetAll(ctx context.Context) ([]*models.TransportationMode, error)
+	// Create sets the ID of the mode
+	Create(ctx context.Context, mode *models.TransportationMode) error
+	Update(ctx context.Context, mode models.TransportationMode) error
+	// Delete fails with ErrModeInUse if trips were made with the mode or
+	// users chose it as their baseline, it can only be disabled then
+	Delete(ctx context.Context, modeID int) error
+}
+
+// ChallengeRepository stores the Challenges table
+type ChallengeRepository interface {
+	// Create sets the ID of the challenge
+	Create(ctx context.Context, challenge *models.Challenge) error
+	GetByID(ctx context.Context, challengeID int) (*models.Challenge, error)
+	GetAll(ctx context.Context) ([]models.Challenge, error)
+	Update(ctx context.Context, challenge models.Challenge) error
+	Delete(ctx context.Context, challengeID int) error
+}
+
+// AuditRepository stores the AuditLog table
+type AuditRepository interface {
+	Create(ctx context.Context, entry models.AuditEntry) error
+	// List returns a page of the entries, latest first
+	List(ctx context.Context, limit, offset int) ([]models.AuditEntry, error)
+	// AnonymizeUser removes the user from the entries of their actions and
+	// their email from the entries about them, which are kept
+	AnonymizeUser(ctx context.Context, userID int) error
+}
+
+// ParticipationRepository stores the ChallengeParticipation table
+type ParticipationRepository interface {
+	GetByUser(ctx context.Context, userID int) ([]models.ChallengeParticipation, error)
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// RecommendationRepository stores the Recommendations table
+type RecommendationRepository interface {
+	GetByUser(ctx context.Context, userID int) ([]models.Recommendation, error)
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// AccountDeletionRepository stores the AccountDeletions table, at most one
+// deletion by user
+type AccountDeletionRepository interface {
+	// Upsert creates or replaces the deletion of the user
+	Upsert(ctx context.Context, deletion models.AccountDeletion) error
+	GetByUser(ctx context.Context, userID int) (*models.AccountDeletion, error)
+	// GetByUserForUpdate also locks the deletion until the end of the transaction
+	GetByUserForUpdate(ctx context.Context, userID int) (*models.AccountDeletion, error)
+	// Due returns the deletions scheduled at or before now
+	Due(ctx context.Context, now time.Time) ([]models.AccountDeletion, error)
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// ReportJobRepository stores the ReportJobs table, one job by user and period
+type ReportJobRepository interface {
+	// Start creates or replaces the job of the user for the period with job,
+	// unless a job with the same status requested since staleBefore is there.
+	// It returns the job kept and whether it is the new one.
+	Start(ctx context.Context, job models.ReportJob, staleBefore time.Time) (*models.ReportJob, bool, error)
+	// Finish sets the status, the error and the generation time of the job,
+	// unless it was requested again since
+	Finish(ctx context.Context, job models.ReportJob) error
+	Get(ctx context.Context, userID int, period string) (*models.ReportJob, error)
+	GetByUser(ctx context.Context, userID int) ([]models.ReportJob, error)
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// BudgetRepository stores the CarbonBudgets table
+type BudgetRepository interface {
+	GetByUser(ctx context.Context, userID int) (*models.CarbonBudget, error)
+	// GetByUserForUpdate also locks the budget until the end of the transaction
+	GetByUserForUpdate(ctx context.Context, userID int) (*models.CarbonBudget, error)
+	// Upsert creates or replaces the budget of the user and resets its alerts
+	Upsert(ctx context.Context, userID int, period string, limitKg float64) error
+	UpdateAlertState(ctx context.Context, budgetID, threshold int, periodStart time.Time) error
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// NotificationRepository stores the Notifications table
+type NotificationRepository interface {
+	Create(ctx context.Context, userID int, notificationType, message string) error
+	GetByUser(ctx context.Context, userID int) ([]models.Notification, error)
+	MarkRead(ctx context.Context, userID, notificationID int) error
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// RateLimitRepository stores the token buckets of the rate limits
+type RateLimitRepository interface {
+	// Take takes a token from the bucket of key, holding up to burst tokens
+	// refilled at rate tokens per second. It returns 0 when a token was taken,
+	// otherwise the time until one is available.
+	Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error)
+	// Prune removes the buckets unused for idle, full again by then
+	Prune(ctx context.Context, idle time.Duration) (int, error)
+}
+
+// LoginAttemptRepository stores the failed logins and the lockouts, by login
+type LoginAttemptRepository interface {
+	// LockedUntil returns the end of the lockout of key, zero when not locked
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// RecordFailure counts a failed login and returns the number of failures
+	// in a row, starting over when the last one is older than window
+	RecordFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset forgets the failures and the lockout, after a successful login
+	Reset(ctx context.Context, key string) error
+	// ResetPrefix forgets the failures and the lockouts of the keys starting
+	// with prefix
+	ResetPrefix(ctx context.Context, prefix string) error
+	// Prune forgets the failures older than window that are not locked out
+	Prune(ctx context.Context, window time.Duration) (int, error)
+}
+
+// Store groups the repositories and holds the operations spanning several of them
+type Store struct {
+	Users         UserRepository
+	EmailChanges  EmailChangeRepository
+	Trips         TripRepository
+	Modes         TransportationModeRepository
+	Budgets       BudgetRepository
+	Notifications NotificationRepository
+	Challenges    ChallengeRepository
+	Audit         AuditRepository
+	// Participations and Recommendations are only exported and deleted along
+	// with the data of their user
+	Participations   ParticipationRepository
+	Recommendations  RecommendationRepository
+	AccountDeletions AccountDeletionRepository
+	ReportJobs       ReportJobRepository
+	RateLimits       RateLimitRepository
+	LoginAttempts    LoginAttemptRepository
+
+	// Providers calls the geocoding and Impact CO₂ APIs
+	Providers *utils.Client
+
+	// withTx runs fn with a Store bound to a transaction, nil inside a transaction
+	withTx func(ctx context.Context, fn func(tx *Store) error) error
+	// ping checks the database is reachable, nil for the memory store
+	ping func(ctx context.Context) error
+}
+
+// Ping checks that the database is reachable
+func (s *Store) Ping(ctx context.Context) error {
+	if s.ping == nil {
+		return nil
+	}
+	return s.ping(ctx)
+}
+
+// WithTx runs fn in a transaction, committed if fn returns nil and rolled back
+// otherwise. Nested calls reuse the enclosing transaction.
+func (s *Store) WithTx(ctx context.Context, fn func(tx *Store) error) error {
+	if s.withTx == nil {
+		return fn(s)
+	}
+	return s.withTx(ctx, fn)
+}
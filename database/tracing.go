@@ -0,0 +1,60 @@
+package database
+
+import (
+	"API/telemetry"
+	"context"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// slowQuery is the duration above which a query is logged
+const slowQuery = 500 * time.Millisecond
+
+// queryTracer wraps every query of the pool in a span
+type queryTracer struct{}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	at  time.Time
+	sql string
+}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = telemetry.Tracer().Start(ctx, "db "+queryOperation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+		),
+	)
+	return context.WithValue(ctx, queryStartKey{}, queryStart{at: time.Now(), sql: data.SQL})
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	} else {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+
+	if start, ok := ctx.Value(queryStartKey{}).(queryStart); ok {
+		if elapsed := time.Since(start.at); elapsed > slowQuery {
+			slog.WarnContext(ctx, "Slow query", "duration_ms", elapsed.Milliseconds(), "query", start.sql)
+		}
+	}
+}
+
+// queryOperation returns the first keyword of the query, e.g. SELECT
+func queryOperation(sql string) string {
+	operation, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	return strings.ToUpper(operation)
+}
@@ -2,34 +2,51 @@ package database
 
 import (
 	"API/models"
-	"database/sql"
+	"context"
 	"fmt"
+	"github.com/jackc/pgx/v5"
 )
 
-func GetTransportationModeByID(modeID int) (*models.TransportationMode, error) {
-	query := `SELECT mode_id, mode_name, description FROM transportationmodes WHERE mode_id = $1`
-	row := DbInstance.DB.QueryRow(query, modeID)
+type postgresTransportationModeRepository struct {
+	db querier
+}
+
+const modeColumns = `mode_id, mode_name, description, category, icon, emission_factor_kg_per_km, impact_co2_id, enabled, names, descriptions`
+
+func scanMode(row pgx.Row) (*models.TransportationMode, error) {
 	mode := &models.TransportationMode{}
-	if err := row.Scan(&mode.ModeID, &mode.ModeName, &mode.Description); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("mode not found")
+	err := row.Scan(&mode.ModeID, &mode.ModeName, &mode.Description, &mode.Category, &mode.Icon,
+		&mode.EmissionFactorKgPerKm, &mode.ImpactCO2ID, &mode.Enabled, &mode.Names, &mode.Descriptions)
+	return mode, err
+}
+
+func (r *postgresTransportationModeRepository) GetByID(ctx context.Context, modeID int) (*models.TransportationMode, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + modeColumns + ` FROM transportationmodes WHERE mode_id = $1`
+	mode, err := scanMode(r.db.QueryRow(ctx, query, modeID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrModeNotFound
 		}
 		return nil, fmt.Errorf("failed to get mode: %w", err)
 	}
 	return mode, nil
 }
 
-func GetAllTransportationModes() ([]*models.TransportationMode, error) {
-	query := `SELECT mode_id, mode_name, description FROM transportationmodes`
-	rows, err := DbInstance.DB.Query(query)
+func (r *postgresTransportationModeRepository) GetAll(ctx context.Context) ([]*models.TransportationMode, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + modeColumns + ` FROM transportationmodes ORDER BY mode_id`
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get modes: %w", err)
 	}
 	defer rows.Close()
 	var modes []*models.TransportationMode
 	for rows.Next() {
-		mode := &models.TransportationMode{}
-		if err := rows.Scan(&mode.ModeID, &mode.ModeName, &mode.Description); err != nil {
+		mode, err := scanMode(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to get mode: %w", err)
 		}
 		modes = append(modes, mode)
@@ -40,3 +57,59 @@ func GetAllTransportationModes() ([]*models.TransportationMode, error) {
 	}
 	return modes, nil
 }
+
+// translations returns the values by language of a mode, never nil as the
+// columns are NOT NULL
+func translations(values map[string]string) map[string]string {
+	if values == nil {
+		return map[string]string{}
+	}
+	return values
+}
+
+func (r *postgresTransportationModeRepository) Create(ctx context.Context, mode *models.TransportationMode) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO transportationmodes (mode_name, description, category, icon, emission_factor_kg_per_km, impact_co2_id, enabled, names, descriptions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING mode_id`
+	err := r.db.QueryRow(ctx, query, mode.ModeName, mode.Description, mode.Category, mode.Icon,
+		mode.EmissionFactorKgPerKm, mode.ImpactCO2ID, mode.Enabled, translations(mode.Names), translations(mode.Descriptions)).Scan(&mode.ModeID)
+	if err != nil {
+		return fmt.Errorf("failed to create mode: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresTransportationModeRepository) Update(ctx context.Context, mode models.TransportationMode) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE transportationmodes SET mode_name = $1, description = $2, category = $3, icon = $4,
+		emission_factor_kg_per_km = $5, impact_co2_id = $6, enabled = $7, names = $8, descriptions = $9
+		WHERE mode_id = $10`
+	res, err := r.db.Exec(ctx, query, mode.ModeName, mode.Description, mode.Category, mode.Icon,
+		mode.EmissionFactorKgPerKm, mode.ImpactCO2ID, mode.Enabled, translations(mode.Names), translations(mode.Descriptions), mode.ModeID)
+	if err != nil {
+		return fmt.Errorf("failed to update mode: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrModeNotFound
+	}
+	return nil
+}
+
+func (r *postgresTransportationModeRepository) Delete(ctx context.Context, modeID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM transportationmodes WHERE mode_id = $1`
+	res, err := r.db.Exec(ctx, query, modeID)
+	if err != nil {
+		if isForeignKeyViolation(err, "trips_mode_id_fkey") || isForeignKeyViolation(err, "users_baseline_mode_id_fkey") {
+			return ErrModeInUse
+		}
+		return fmt.Errorf("failed to delete mode: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrModeNotFound
+	}
+	return nil
+}
@@ -1,21 +1,37 @@
 package database
 
 import (
+	"API/i18n"
+	"API/metrics"
 	"API/models"
 	"API/utils"
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"sort"
 	"time"
 )
 
-func RegisterTrip(startAddress, endAddress, carBrand, carModel string, distanceKm float64, modeID int, user_id int, tripDate string) error {
+// DefaultBaselineModeID is the mode of a petrol car driven alone, used as the
+// baseline when the user has not chosen one
+const DefaultBaselineModeID = 4
+
+// RegisterTrip records a trip of the user. tripDate is an RFC 3339 timestamp
+// or a date in the time zone of the user, now when empty. The budget alerts
+// are sent in the language lang.
+func (s *Store) RegisterTrip(ctx context.Context, startAddress, endAddress, carBrand, carModel string, distanceKm float64, modeID int, user_id int, tripDate, lang string) error {
+	user, err := s.Users.GetByID(ctx, user_id)
+	if err != nil {
+		return err
+	}
 	tripTime := time.Now()
 	if tripDate != "" {
 		// convert the date string to a time.Time
-		var err error
-		tripTime, err = utils.ConvertStringToTime(tripDate)
+		tripTime, err = utils.ParseDateTime(tripDate, user.Location())
 		if err != nil {
-			return fmt.Errorf("failed to convert trip date: %w", err)
+			return inputError("invalid trip_date, expected YYYY-MM-DD or an RFC 3339 timestamp")
 		}
 	}
 	trip := &models.Trip{
@@ -26,7 +42,7 @@ func RegisterTrip(startAddress, endAddress, carBrand, carModel string, distanceK
 
 	// if the distance is 0 then use the address to calculate the distance
 	if distanceKm == 0 {
-		d, err := utils.CalculateDistance(startAddress, endAddress)
+		d, err := s.Providers.CalculateDistance(ctx, startAddress, endAddress)
 		if err != nil {
 			return fmt.Errorf("failed to calculate distance: %w", err)
 		}
@@ -37,17 +53,136 @@ func RegisterTrip(startAddress, endAddress, carBrand, carModel string, distanceK
 		trip.DistanceKm = &distanceKm
 	}
 
-	carbonImpactKg, err := utils.GetCarbonImpactByMode(modeID, *trip.DistanceKm)
+	mode, err := s.Modes.GetByID(ctx, modeID)
+	if err != nil {
+		return err
+	}
+	modes := []*models.TransportationMode{mode}
+	baselineModeID, err := s.GetUserBaselineModeID(ctx, user_id)
+	if err != nil {
+		return err
+	}
+	// without its baseline mode the trip counts as no savings
+	baselineMode, err := s.Modes.GetByID(ctx, baselineModeID)
+	if err == nil {
+		modes = append(modes, baselineMode)
+	} else if !errors.Is(err, ErrModeNotFound) {
+		return err
+	}
+
+	// get the impact of the trip and of the baseline in one call
+	impacts, err := s.carbonImpacts(ctx, modes, *trip.DistanceKm)
 	if err != nil {
 		return fmt.Errorf("failed to get carbon impact: %w", err)
 	}
+	carbonImpactKg, ok := impacts[modeID]
+	if !ok {
+		return fmt.Errorf("failed to get carbon impact: %w: no CO₂ data for mode: %d", utils.ErrProviderUnavailable, modeID)
+	}
 	trip.CarbonImpactKg = &carbonImpactKg
+	if baselineImpactKg, ok := impacts[baselineModeID]; ok {
+		trip.BaselineImpactKg = &baselineImpactKg
+	}
+
+	if err := s.Trips.Create(ctx, trip); err != nil {
+		return err
+	}
+	metrics.TripsCreated.Inc()
+	metrics.CarbonRecorded.Add(carbonImpactKg)
+
+	// the trip is saved even if the budget alerts fail
+	if err := s.CheckBudgetAlerts(ctx, user_id, lang); err != nil {
+		slog.ErrorContext(ctx, "Error checking budget alerts", "error", err)
+	}
+	return nil
+}
+
+// CompareTripModes returns the carbon impact of every transportation mode for a
+// planned trip, with the mode names in the language lang. The distance is
+// computed from the addresses when distanceKm is 0.
+func (s *Store) CompareTripModes(ctx context.Context, lang, startAddress, endAddress string, distanceKm float64, withDuration bool) (float64, []models.ModeComparison, error) {
+	if distanceKm == 0 {
+		d, err := s.Providers.CalculateDistance(ctx, startAddress, endAddress)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to calculate distance: %w", err)
+		}
+		distanceKm = d
+	}
 
-	return CreateTrip(trip)
+	allModes, err := s.Modes.GetAll(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	var modes []*models.TransportationMode
+	for _, mode := range allModes {
+		if mode.Enabled {
+			modes = append(modes, mode)
+		}
+	}
+
+	impacts, err := s.carbonImpacts(ctx, modes, distanceKm)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get carbon impact: %w", err)
+	}
+
+	comparisons := []models.ModeComparison{}
+	for _, mode := range modes {
+		impact, ok := impacts[mode.ModeID]
+		if !ok {
+			continue
+		}
+		comparison := models.ModeComparison{
+			ModeID:         mode.ModeID,
+			ModeName:       i18n.Pick(lang, mode.Names, mode.ModeName),
+			CarbonImpactKg: impact,
+		}
+		if withDuration && mode.ImpactCO2ID != nil {
+			if minutes, ok := utils.EstimateDurationMinutes(*mode.ImpactCO2ID, distanceKm); ok {
+				comparison.DurationMinutes = &minutes
+			}
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	// lowest footprint first
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].CarbonImpactKg < comparisons[j].CarbonImpactKg
+	})
+	return distanceKm, comparisons, nil
+}
+
+// carbonImpacts returns the carbon impact in kg of a trip of distanceKm with
+// each mode, by mode ID. The modes mapped to Impact CO₂ are priced in a single
+// call, the others and those it has no data for with their emission factor.
+// The modes with neither are left out.
+func (s *Store) carbonImpacts(ctx context.Context, modes []*models.TransportationMode, distanceKm float64) (map[int]float64, error) {
+	var transportIDs []int
+	for _, mode := range modes {
+		if mode.ImpactCO2ID != nil {
+			transportIDs = append(transportIDs, *mode.ImpactCO2ID)
+		}
+	}
+	transportImpacts, err := s.Providers.GetCarbonImpactForModes(ctx, transportIDs, distanceKm)
+	if err != nil {
+		return nil, err
+	}
+
+	impacts := make(map[int]float64, len(modes))
+	for _, mode := range modes {
+		if mode.ImpactCO2ID != nil {
+			if impact, ok := transportImpacts[*mode.ImpactCO2ID]; ok {
+				impacts[mode.ModeID] = impact
+				continue
+			}
+		}
+		if mode.EmissionFactorKgPerKm != nil {
+			impacts[mode.ModeID] = *mode.EmissionFactorKgPerKm * distanceKm
+		}
+	}
+	return impacts, nil
 }
 
-func TotalCarbonImpact(userID int) (float64, error) {
-	trips, err := GetUserTrips(userID)
+func (s *Store) TotalCarbonImpact(ctx context.Context, userID int) (float64, error) {
+	trips, err := s.GetUserTrips(ctx, userID)
 	if err != nil {
 		return 0, err
 	}
@@ -58,18 +193,79 @@ func TotalCarbonImpact(userID int) (float64, error) {
 	return total, nil
 }
 
-func AggregateUserTripsByMode(userID int) ([]models.TripsByMode, error) {
+// TripAvoidedImpact returns the carbon avoided by a trip compared to its baseline.
+// Trips recorded without a baseline count as no savings.
+func TripAvoidedImpact(trip models.Trip) float64 {
+	if trip.BaselineImpactKg == nil || trip.CarbonImpactKg == nil {
+		return 0
+	}
+	return *trip.BaselineImpactKg - *trip.CarbonImpactKg
+}
+
+// TotalCarbonSavings returns the emitted, baseline and avoided carbon over all the user's trips
+func (s *Store) TotalCarbonSavings(ctx context.Context, userID int) (models.Savings, error) {
+	trips, err := s.GetUserTrips(ctx, userID)
+	if err != nil {
+		return models.Savings{}, err
+	}
+	return sumSavings("", trips), nil
+}
+
+// MonthlyCarbonSavings returns the savings of the user for each month with trips, oldest first.
+// The months are the ones of the time zone of the user.
+func (s *Store) MonthlyCarbonSavings(ctx context.Context, userID int) ([]models.Savings, error) {
+	user, err := s.Users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	trips, err := s.Trips.GetByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	loc := user.Location()
+	byMonth := make(map[string][]models.Trip)
+	for _, trip := range trips {
+		month := trip.TripDate.In(loc).Format("2006-01")
+		byMonth[month] = append(byMonth[month], trip)
+	}
+	savings := []models.Savings{}
+	for month, monthTrips := range byMonth {
+		savings = append(savings, sumSavings(month, monthTrips))
+	}
+	sort.Slice(savings, func(i, j int) bool {
+		return savings[i].Period < savings[j].Period
+	})
+	return savings, nil
+}
+
+func sumSavings(period string, trips []models.Trip) models.Savings {
+	savings := models.Savings{Period: period}
+	for _, trip := range trips {
+		avoided := TripAvoidedImpact(trip)
+		savings.TotalImpact += *trip.CarbonImpactKg
+		savings.TotalBaseline += *trip.CarbonImpactKg + avoided
+		savings.TotalAvoided += avoided
+	}
+	return savings
+}
+
+func (s *Store) AggregateUserTripsByMode(ctx context.Context, userID int) ([]models.TripsByMode, error) {
 	// get all the trips for the user
-	trips, err := GetUserTrips(userID)
+	trips, err := s.GetUserTrips(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
+	return s.AggregateTripsByMode(ctx, trips)
+}
+
+// AggregateTripsByMode totals the given trips per transportation mode
+func (s *Store) AggregateTripsByMode(ctx context.Context, trips []models.Trip) ([]models.TripsByMode, error) {
 	// get all the transportation modes used by the user
 	var modes map[int]*models.TripsByMode = make(map[int]*models.TripsByMode)
 	for _, trip := range trips {
 		// if the mode is not in the map then add it
 		if _, ok := modes[trip.ModeID]; !ok {
-			mode, err := GetTransportationModeByID(trip.ModeID)
+			mode, err := s.Modes.GetByID(ctx, trip.ModeID)
 			if err != nil {
 				return nil, err
 			}
@@ -78,12 +274,14 @@ func AggregateUserTripsByMode(userID int) ([]models.TripsByMode, error) {
 				TotalTrips:    1,
 				TotalImpact:   *trip.CarbonImpactKg,
 				TotalDistance: *trip.DistanceKm,
+				TotalAvoided:  TripAvoidedImpact(trip),
 			}
 		} else {
 			tripsByMode := modes[trip.ModeID]
 			tripsByMode.TotalTrips++
 			tripsByMode.TotalImpact += *trip.CarbonImpactKg
 			tripsByMode.TotalDistance += *trip.DistanceKm
+			tripsByMode.TotalAvoided += TripAvoidedImpact(trip)
 		}
 	}
 	// convert the map to a slice
@@ -94,43 +292,110 @@ func AggregateUserTripsByMode(userID int) ([]models.TripsByMode, error) {
 	return tripsByMode, nil
 }
 
-func CreateTrip(trip *models.Trip) error {
-	query := `INSERT INTO trips (user_id, start_address, end_address, distance_km, mode_id, carbon_impact_kg, trip_date, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err := DbInstance.DB.Exec(query, trip.UserID, trip.StartAddress, trip.EndAddress, trip.DistanceKm, trip.ModeID, trip.CarbonImpactKg, trip.TripDate, trip.CreatedAt)
+type postgresTripRepository struct {
+	db querier
+}
+
+func (r *postgresTripRepository) Create(ctx context.Context, trip *models.Trip) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO trips (user_id, start_address, end_address, distance_km, mode_id, carbon_impact_kg, baseline_impact_kg, trip_date, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := r.db.Exec(ctx, query, trip.UserID, trip.StartAddress, trip.EndAddress, trip.DistanceKm, trip.ModeID, trip.CarbonImpactKg, trip.BaselineImpactKg, trip.TripDate, trip.CreatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to create trip: %w", err)
 	}
 	return nil
 }
 
-func GetTripByID(tripID int) (*models.Trip, error) {
-	query := `SELECT trip_id, user_id, start_address, end_address, distance_km, mode_id, carbon_impact_kg, trip_date, created_at FROM trips WHERE trip_id = $1`
-	row := DbInstance.DB.QueryRow(query, tripID)
+func (r *postgresTripRepository) GetByID(ctx context.Context, tripID int) (*models.Trip, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT trip_id, user_id, start_address, end_address, distance_km, mode_id, carbon_impact_kg, baseline_impact_kg, trip_date, created_at FROM trips WHERE trip_id = $1`
+	row := r.db.QueryRow(ctx, query, tripID)
 	trip := &models.Trip{}
-	if err := row.Scan(&trip.TripID, &trip.UserID, &trip.StartAddress, &trip.EndAddress, &trip.DistanceKm, &trip.ModeID, &trip.CarbonImpactKg, &trip.TripDate, &trip.CreatedAt); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("trip not found")
+	if err := row.Scan(&trip.TripID, &trip.UserID, &trip.StartAddress, &trip.EndAddress, &trip.DistanceKm, &trip.ModeID, &trip.CarbonImpactKg, &trip.BaselineImpactKg, &trip.TripDate, &trip.CreatedAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTripNotFound
 		}
 		return nil, fmt.Errorf("failed to get trip: %w", err)
 	}
 	return trip, nil
 }
 
-func UpdateTrip(trip *models.Trip) error {
-	query := `UPDATE trips SET user_id = $1, start_address = $2, end_address = $3, distance_km = $4, mode_id = $5, carbon_impact_kg = $6, trip_date = $7, created_at = $8 WHERE trip_id = $9`
-	_, err := DbInstance.DB.Exec(query, trip.UserID, trip.StartAddress, trip.EndAddress, trip.DistanceKm, trip.ModeID, trip.CarbonImpactKg, trip.TripDate, trip.CreatedAt, trip.TripID)
+func (r *postgresTripRepository) GetByUser(ctx context.Context, userID int) ([]models.Trip, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT trip_id, user_id, start_address, end_address, distance_km, mode_id, carbon_impact_kg, baseline_impact_kg, trip_date, created_at 
+		FROM Trips WHERE user_id = $1`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error retrieving trips", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trips []models.Trip
+	for rows.Next() {
+		var trip models.Trip
+		if err := rows.Scan(
+			&trip.TripID,
+			&trip.UserID,
+			&trip.StartAddress,
+			&trip.EndAddress,
+			&trip.DistanceKm,
+			&trip.ModeID,
+			&trip.CarbonImpactKg,
+			&trip.BaselineImpactKg,
+			&trip.TripDate,
+			&trip.CreatedAt,
+		); err != nil {
+			slog.ErrorContext(ctx, "Error scanning trip row", "error", err)
+			return nil, err
+		}
+		trips = append(trips, trip)
+	}
+
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "Error after iterating rows", "error", err)
+		return nil, err
+	}
+	if len(trips) == 0 {
+		return []models.Trip{}, nil
+	}
+
+	return trips, nil
+}
+
+func (r *postgresTripRepository) Update(ctx context.Context, trip *models.Trip) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE trips SET user_id = $1, start_address = $2, end_address = $3, distance_km = $4, mode_id = $5, carbon_impact_kg = $6, baseline_impact_kg = $7, trip_date = $8, created_at = $9 WHERE trip_id = $10`
+	_, err := r.db.Exec(ctx, query, trip.UserID, trip.StartAddress, trip.EndAddress, trip.DistanceKm, trip.ModeID, trip.CarbonImpactKg, trip.BaselineImpactKg, trip.TripDate, trip.CreatedAt, trip.TripID)
 	if err != nil {
 		return fmt.Errorf("failed to update trip: %w", err)
 	}
 	return nil
 }
 
-func DeleteTrip(tripID int) error {
+func (r *postgresTripRepository) Delete(ctx context.Context, tripID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	query := `DELETE FROM trips WHERE trip_id = $1`
-	_, err := DbInstance.DB.Exec(query, tripID)
+	_, err := r.db.Exec(ctx, query, tripID)
 	if err != nil {
 		return fmt.Errorf("failed to delete trip: %w", err)
 	}
 	return nil
 }
+
+func (r *postgresTripRepository) DeleteByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `DELETE FROM trips WHERE user_id = $1`
+	if _, err := r.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete trips: %w", err)
+	}
+	return nil
+}
@@ -1,75 +1,65 @@
 package database
 
 import (
+	"API/metrics"
 	"API/models"
-	"database/sql"
+	"context"
 	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
 	"golang.org/x/crypto/bcrypt"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
 )
 
-func CheckUserCredentials(email, password string) (int, error) {
+// CheckUserCredentials returns the user with the email and password, unless
+// the account is disabled
+func (s *Store) CheckUserCredentials(ctx context.Context, email, password string) (*models.User, error) {
 	// return error if email is empty
 	if email == "" {
-		return 0, errors.New("email is empty")
+		return nil, inputError("email is empty")
 	}
 
-	var query string
-	query = `SELECT user_id, password_hash FROM Users WHERE email = $1`
-	row := DbInstance.DB.QueryRow(query, email)
-	var userID int
-	var passwordHash string
-	if err := row.Scan(&userID, &passwordHash); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return 0, errors.New("user not found")
-		}
-		log.Println("Error retrieving user:", err)
-		return 0, err
+	// an unknown email and a wrong password give the same error
+	user, err := s.Users.GetByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
-		return 0, errors.New("incorrect password")
+		return nil, ErrInvalidCredentials
 	}
-	return userID, nil
+	if user.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
+	return user, nil
 }
 
-func RegisterUserFromEmail(email, username, password string) (int, error) {
+func (s *Store) RegisterUserFromEmail(ctx context.Context, email, username, password string) (int, error) {
 
 	// return error if email is empty
 	if email == "" {
-		return 0, errors.New("email is empty")
+		return 0, inputError("email is empty")
 	}
 
 	// return error if username is empty
 	if username == "" {
-		return 0, errors.New("username is empty")
+		return 0, inputError("username is empty")
 	}
 
 	// return error if password is empty
 	if password == "" {
-		return 0, errors.New("password is empty")
-	}
-
-	// check if email already exists
-	query := `SELECT user_id FROM Users WHERE email = $1`
-	row := DbInstance.DB.QueryRow(query, email)
-	var userID int
-	if err := row.Scan(&userID); err == nil {
-		return 0, errors.New("email already exists")
-	}
-
-	// check if username already exists
-	query = `SELECT user_id FROM Users WHERE username = $1`
-	row = DbInstance.DB.QueryRow(query, username)
-	if err := row.Scan(&userID); err == nil {
-		return 0, errors.New("username already exists")
+		return 0, inputError("password is empty")
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Println("Error hashing password:", err)
+		slog.ErrorContext(ctx, "Error hashing password", "error", err)
 		return 0, err
 	}
 	user := models.User{
@@ -77,38 +67,63 @@ func RegisterUserFromEmail(email, username, password string) (int, error) {
 		Username:     username,
 		PasswordHash: string(hashedPassword),
 	}
-	return CreateUser(user)
+	// duplicates are rejected by the unique constraints on email and username
+	userID, err := s.Users.Create(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+	metrics.UsersRegistered.Inc()
+	return userID, nil
 }
 
-// CreateUser creates a new user in the database
-func CreateUser(user models.User) (int, error) {
-	query := `INSERT INTO Users (email, username, password_hash, google_id, github_id, created_at, updated_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING user_id`
+// ChangePassword replaces the password of the user, given the current one
+func (s *Store) ChangePassword(ctx context.Context, userID int, currentPassword, newPassword string) error {
+	user, err := s.Users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(user, currentPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error hashing password", "error", err)
+		return err
+	}
+	user.PasswordHash = string(hashedPassword)
+	return s.Users.Update(ctx, *user)
+}
 
-	var userID int
-	err := DbInstance.DB.QueryRow(query,
-		user.Email,
-		user.Username,
-		user.PasswordHash,
-		user.GoogleID,
-		user.GithubID,
-		time.Now(),
-		time.Now(),
-	).Scan(&userID)
+func (s *Store) GetUserTrips(ctx context.Context, userID int) ([]models.Trip, error) {
+	// Check if user exists
+	_, err := s.Users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Trips.GetByUser(ctx, userID)
+}
+
+// GetUserBaselineModeID returns the baseline mode of the user, or the default one if not set
+func (s *Store) GetUserBaselineModeID(ctx context.Context, userID int) (int, error) {
+	user, err := s.Users.GetByID(ctx, userID)
 	if err != nil {
-		log.Println("Error creating user:", err)
 		return 0, err
 	}
+	if user.BaselineModeID == nil {
+		return DefaultBaselineModeID, nil
+	}
+	return *user.BaselineModeID, nil
+}
 
-	return userID, nil
+type postgresUserRepository struct {
+	db querier
 }
 
-// GetUser retrieves a user by their ID
-func GetUser(userID int) (*models.User, error) {
-	query := `SELECT user_id, email, username, password_hash, google_id, github_id, created_at, updated_at 
-		FROM Users WHERE user_id = $1`
+const userColumns = `user_id, email, username, password_hash, google_id, github_id, baseline_mode_id, role, language, units, timezone, default_mode_id, avatar_key, disabled_at, created_at, updated_at`
 
-	row := DbInstance.DB.QueryRow(query, userID)
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
 	var user models.User
 	if err := row.Scan(
 		&user.UserID,
@@ -117,137 +132,340 @@ func GetUser(userID int) (*models.User, error) {
 		&user.PasswordHash,
 		&user.GoogleID,
 		&user.GithubID,
+		&user.BaselineModeID,
+		&user.Role,
+		&user.Language,
+		&user.Units,
+		&user.Timezone,
+		&user.DefaultModeID,
+		&user.AvatarKey,
+		&user.DisabledAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("user not found")
-		}
-		log.Println("Error retrieving user:", err)
 		return nil, err
 	}
-
 	return &user, nil
 }
 
-func GetUserTrips(userID int) ([]models.Trip, error) {
-	// Check if user exists
-	_, err := GetUser(userID)
+// getOne retrieves the user matching the condition
+func (r *postgresUserRepository) getOne(ctx context.Context, condition string, arg interface{}) (*models.User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + userColumns + ` FROM Users WHERE ` + condition
+
+	user, err := scanUser(r.db.QueryRow(ctx, query, arg))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		slog.ErrorContext(ctx, "Error retrieving user", "error", err)
 		return nil, err
 	}
 
-	query := `SELECT trip_id, user_id, start_address, end_address, distance_km, mode_id, carbon_impact_kg, trip_date, created_at 
-		FROM Trips WHERE user_id = $1`
+	return user, nil
+}
 
-	rows, err := DbInstance.DB.Query(query, userID)
-	if err != nil {
-		log.Println("Error retrieving trips:", err)
-		return nil, err
-	}
-	defer rows.Close()
+// Create creates a new user in the database
+func (r *postgresUserRepository) Create(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO Users (email, username, password_hash, google_id, github_id, baseline_mode_id, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING user_id`
 
-	var trips []models.Trip
-	for rows.Next() {
-		var trip models.Trip
-		if err := rows.Scan(
-			&trip.TripID,
-			&trip.UserID,
-			&trip.StartAddress,
-			&trip.EndAddress,
-			&trip.DistanceKm,
-			&trip.ModeID,
-			&trip.CarbonImpactKg,
-			&trip.TripDate,
-			&trip.CreatedAt,
-		); err != nil {
-			log.Println("Error scanning trip row:", err)
-			return nil, err
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	var userID int
+	err := r.db.QueryRow(ctx, query,
+		user.Email,
+		user.Username,
+		user.PasswordHash,
+		user.GoogleID,
+		user.GithubID,
+		user.BaselineModeID,
+		user.Role,
+		time.Now(),
+		time.Now(),
+	).Scan(&userID)
+	if err != nil {
+		if isUniqueViolation(err, "users_email_key") {
+			return 0, ErrEmailExists
+		}
+		if isUniqueViolation(err, "users_username_key") {
+			return 0, ErrUsernameExists
 		}
-		trips = append(trips, trip)
+		slog.ErrorContext(ctx, "Error creating user", "error", err)
+		return 0, err
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Println("Error after iterating rows:", err)
-		return nil, err
-	}
-	if len(trips) == 0 {
-		return []models.Trip{}, nil
-	}
+	return userID, nil
+}
 
-	return trips, nil
+// GetByID retrieves a user by their ID
+func (r *postgresUserRepository) GetByID(ctx context.Context, userID int) (*models.User, error) {
+	return r.getOne(ctx, `user_id = $1`, userID)
 }
 
-// UpdateUser updates an existing user's details
-func UpdateUser(user models.User) error {
-	query := `UPDATE Users SET email = $1, username = $2, password_hash = $3, google_id = $4, github_id = $5, updated_at = $6 
-		WHERE user_id = $7`
+// GetByEmail retrieves a user by their email
+func (r *postgresUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.getOne(ctx, `email = $1`, email)
+}
 
-	_, err := DbInstance.DB.Exec(query,
+// GetByUsername retrieves a user by their username
+func (r *postgresUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.getOne(ctx, `username = $1`, username)
+}
+
+// Update updates an existing user's details
+func (r *postgresUserRepository) Update(ctx context.Context, user models.User) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE Users SET email = $1, username = $2, password_hash = $3, google_id = $4, github_id = $5, baseline_mode_id = $6, updated_at = $7
+		WHERE user_id = $8`
+
+	res, err := r.db.Exec(ctx, query,
 		user.Email,
 		user.Username,
 		user.PasswordHash,
 		user.GoogleID,
 		user.GithubID,
+		user.BaselineModeID,
 		time.Now(),
 		user.UserID,
 	)
 	if err != nil {
-		log.Println("Error updating user:", err)
+		if isUniqueViolation(err, "users_email_key") {
+			return ErrEmailExists
+		}
+		if isUniqueViolation(err, "users_username_key") {
+			return ErrUsernameExists
+		}
+		slog.ErrorContext(ctx, "Error updating user", "error", err)
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdateBaselineMode sets the mode used as the savings baseline for the user
+func (r *postgresUserRepository) UpdateBaselineMode(ctx context.Context, userID, modeID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE Users SET baseline_mode_id = $1, updated_at = $2 WHERE user_id = $3`
+
+	_, err := r.db.Exec(ctx, query, modeID, time.Now(), userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating user baseline mode", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateRole sets the role of the user
+func (r *postgresUserRepository) UpdateRole(ctx context.Context, userID int, role string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE Users SET role = $1, updated_at = $2 WHERE user_id = $3`
+
+	res, err := r.db.Exec(ctx, query, role, time.Now(), userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating user role", "error", err)
 		return err
 	}
+	if res.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
 
+// UpdateLanguage sets the language of the messages sent to the user
+func (r *postgresUserRepository) UpdateLanguage(ctx context.Context, userID int, language *string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE Users SET language = $1, updated_at = $2 WHERE user_id = $3`
+
+	res, err := r.db.Exec(ctx, query, language, time.Now(), userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating user language", "error", err)
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
 	return nil
 }
 
-// DeleteUser deletes a user by their ID
-func DeleteUser(userID int) error {
+// UpdatePreferences sets the settings of the user
+func (r *postgresUserRepository) UpdatePreferences(ctx context.Context, userID int, preferences models.Preferences) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE Users SET units = $1, timezone = $2, language = $3, default_mode_id = $4, baseline_mode_id = $5, updated_at = $6
+		WHERE user_id = $7`
+
+	res, err := r.db.Exec(ctx, query,
+		preferences.Units,
+		preferences.Timezone,
+		preferences.Language,
+		preferences.DefaultModeID,
+		preferences.BaselineModeID,
+		time.Now(),
+		userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating user preferences", "error", err)
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdateAvatar sets the key of the avatar of the user in the blob storage
+func (r *postgresUserRepository) UpdateAvatar(ctx context.Context, userID int, avatarKey *string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE Users SET avatar_key = $1, updated_at = $2 WHERE user_id = $3`
+
+	res, err := r.db.Exec(ctx, query, avatarKey, time.Now(), userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating user avatar", "error", err)
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetDisabled disables or enables the account of the user
+func (r *postgresUserRepository) SetDisabled(ctx context.Context, userID int, disabled bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `UPDATE Users SET disabled_at = CASE WHEN $1 THEN COALESCE(disabled_at, $2) END, updated_at = $2
+		WHERE user_id = $3`
+
+	res, err := r.db.Exec(ctx, query, disabled, time.Now(), userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating user status", "error", err)
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Delete deletes a user by their ID
+func (r *postgresUserRepository) Delete(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	query := `DELETE FROM Users WHERE user_id = $1`
 
-	_, err := DbInstance.DB.Exec(query, userID)
+	_, err := r.db.Exec(ctx, query, userID)
 	if err != nil {
-		log.Println("Error deleting user:", err)
+		slog.ErrorContext(ctx, "Error deleting user", "error", err)
 		return err
 	}
 
 	return nil
 }
 
-// GetAllUsers retrieves all users from the database
-func GetAllUsers() ([]models.User, error) {
-	query := `SELECT user_id, email, username, password_hash, google_id, github_id, created_at, updated_at 
-		FROM Users`
+// GetAll retrieves all users from the database
+func (r *postgresUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + userColumns + ` FROM Users`
 
-	rows, err := DbInstance.DB.Query(query)
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
-		log.Println("Error retrieving users:", err)
+		slog.ErrorContext(ctx, "Error retrieving users", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	var users []models.User
 	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(
-			&user.UserID,
-			&user.Email,
-			&user.Username,
-			&user.PasswordHash,
-			&user.GoogleID,
-			&user.GithubID,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		); err != nil {
-			log.Println("Error scanning user row:", err)
+		user, err := scanUser(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error scanning user row", "error", err)
 			return nil, err
 		}
-		users = append(users, user)
+		users = append(users, *user)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Println("Error after iterating rows:", err)
+		slog.ErrorContext(ctx, "Error after iterating rows", "error", err)
 		return nil, err
 	}
 
 	return users, nil
 }
+
+// Search retrieves a page of the users matching the filter
+func (r *postgresUserRepository) Search(ctx context.Context, filter UserFilter) ([]models.User, int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var conditions []string
+	var args []interface{}
+	if filter.Query != "" {
+		args = append(args, "%"+escapeLike(filter.Query)+"%")
+		conditions = append(conditions, fmt.Sprintf(`(email ILIKE $%d OR username ILIKE $%d)`, len(args), len(args)))
+	}
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		conditions = append(conditions, fmt.Sprintf(`role = $%d`, len(args)))
+	}
+	if filter.Disabled != nil {
+		if *filter.Disabled {
+			conditions = append(conditions, `disabled_at IS NOT NULL`)
+		} else {
+			conditions = append(conditions, `disabled_at IS NULL`)
+		}
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+
+	var total int
+	if err := r.db.QueryRow(ctx, `SELECT count(*) FROM Users`+where, args...).Scan(&total); err != nil {
+		slog.ErrorContext(ctx, "Error counting users", "error", err)
+		return nil, 0, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`SELECT %s FROM Users%s ORDER BY user_id LIMIT $%d OFFSET $%d`, userColumns, where, len(args)-1, len(args))
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error searching users", "error", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error scanning user row", "error", err)
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "Error after iterating rows", "error", err)
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,180 @@
+// Package i18n translates the messages of the API and formats the numbers and
+// dates with the message catalogs of locales/
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/text/language"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default is the language of the messages in the code, used when the client
+// accepts none of the supported ones
+const Default = "en"
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// catalog is a locales/<language>.json file
+type catalog struct {
+	DecimalSeparator   string `json:"decimal_separator"`
+	ThousandsSeparator string `json:"thousands_separator"`
+	// Date and Month lay out {day}, {month} and {year}
+	Date   string   `json:"date"`
+	Month  string   `json:"month"`
+	Months []string `json:"months"`
+	// Messages translates the English messages, keyed by their format
+	Messages map[string]string `json:"messages"`
+}
+
+var (
+	catalogs = loadCatalogs()
+	// Languages lists the supported languages, the default first
+	Languages = languages()
+	matcher   = newMatcher()
+)
+
+func loadCatalogs() map[string]catalog {
+	files, err := localesFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]catalog, len(files))
+	for _, file := range files {
+		data, err := localesFS.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var c catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			panic(fmt.Sprintf("invalid catalog %s: %v", file.Name(), err))
+		}
+		if len(c.Months) != 12 {
+			panic(fmt.Sprintf("invalid catalog %s: 12 months expected", file.Name()))
+		}
+		catalogs[strings.TrimSuffix(file.Name(), ".json")] = c
+	}
+	if _, ok := catalogs[Default]; !ok {
+		panic("missing catalog of the default language")
+	}
+	return catalogs
+}
+
+func languages() []string {
+	langs := []string{Default}
+	for lang := range catalogs {
+		if lang != Default {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs[1:])
+	return langs
+}
+
+func newMatcher() language.Matcher {
+	tags := make([]language.Tag, len(Languages))
+	for i, lang := range Languages {
+		tags[i] = language.Make(lang)
+	}
+	return language.NewMatcher(tags)
+}
+
+// Supported reports whether lang has a catalog
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Negotiate returns the supported language preferred by an Accept-Language
+// header, e.g. "fr" for "fr-CA,fr;q=0.9,en;q=0.8"
+func Negotiate(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return Default
+	}
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Default
+	}
+	return Languages[index]
+}
+
+func catalogOf(lang string) catalog {
+	if c, ok := catalogs[lang]; ok {
+		return c
+	}
+	return catalogs[Default]
+}
+
+// T translates the English message, formatted with args when given. The
+// messages missing from the catalog are returned in English.
+func T(lang, message string, args ...interface{}) string {
+	if translation, ok := catalogOf(lang).Messages[message]; ok {
+		message = translation
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Pick returns the value for lang among values by language, the one of the
+// default language or fallback when missing
+func Pick(lang string, values map[string]string, fallback string) string {
+	if value, ok := values[lang]; ok && value != "" {
+		return value
+	}
+	if value, ok := values[Default]; ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// FormatNumber formats value with the given number of decimals and the
+// separators of the language, e.g. 1,234.5 or 1 234,5
+func FormatNumber(lang string, value float64, decimals int) string {
+	c := catalogOf(lang)
+	formatted := strconv.FormatFloat(value, 'f', decimals, 64)
+	sign := ""
+	if strings.HasPrefix(formatted, "-") {
+		sign, formatted = "-", formatted[1:]
+	}
+	integer, fraction, _ := strings.Cut(formatted, ".")
+
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteString(c.ThousandsSeparator)
+		}
+		grouped.WriteRune(digit)
+	}
+	if fraction != "" {
+		return sign + grouped.String() + c.DecimalSeparator + fraction
+	}
+	return sign + grouped.String()
+}
+
+// FormatDate formats the day of t, e.g. January 2, 2006 or 2 janvier 2006
+func FormatDate(lang string, t time.Time) string {
+	c := catalogOf(lang)
+	return strings.NewReplacer(
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", c.Months[t.Month()-1],
+		"{year}", strconv.Itoa(t.Year()),
+	).Replace(c.Date)
+}
+
+// FormatMonth formats the month of t, e.g. January 2006 or janvier 2006
+func FormatMonth(lang string, t time.Time) string {
+	c := catalogOf(lang)
+	return strings.NewReplacer(
+		"{month}", c.Months[t.Month()-1],
+		"{year}", strconv.Itoa(t.Year()),
+	).Replace(c.Month)
+}
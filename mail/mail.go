@@ -0,0 +1,66 @@
+// Package mail sends the emails of the API, e.g. the verification of a new
+// email address
+package mail
+
+import (
+	"API/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Sender sends plain text emails
+type Sender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// New returns the sender through the configured SMTP server, or one logging
+// the emails when there is none, for local development
+func New(cfg config.MailConfig) Sender {
+	if cfg.SMTPAddr == "" {
+		return logSender{}
+	}
+	return &smtpSender{cfg: cfg}
+}
+
+type smtpSender struct {
+	cfg config.MailConfig
+}
+
+func (s *smtpSender) Send(ctx context.Context, to, subject, body string) error {
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(s.cfg.SMTPAddr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(s.cfg.SMTPAddr, auth, s.cfg.From, []string{to}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	slog.InfoContext(ctx, "Email sent", "subject", subject)
+	return nil
+}
+
+type logSender struct{}
+
+func (logSender) Send(ctx context.Context, to, subject, body string) error {
+	slog.InfoContext(ctx, "Email not sent, no SMTP server", "to", to, "subject", subject, "body", body)
+	return nil
+}
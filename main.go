@@ -1,17 +1,149 @@
 package main
 
 import (
+	"API/config"
 	"API/database"
+	"API/metrics"
+	"API/models"
 	"API/server"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"API/telemetry"
+	"API/utils"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	// the time zones of the users, on hosts without a zoneinfo database
+	_ "time/tzdata"
 )
 
 func main() {
+	// Load the configuration from config.yaml, .env and the environment
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(1)
+	}
+	// Log as JSON and export the traces
+	telemetry.SetupLogger(cfg.Log)
+	slog.Info("Configuration", "config", cfg.String())
+	shutdownTracing, err := telemetry.SetupTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Connect to the database
-	err := database.InitDB()
+	db, err := database.InitDB(context.Background(), cfg.Database)
 	if err != nil {
 		panic(err)
 	}
-	// Start and initialize the server
-	server.StartAndInitializeServer()
+	defer db.Close()
+	metrics.Registry.MustRegister(db.Collector())
+
+	// `migrate up|down [steps]|status` manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(db, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Apply pending migrations unless disabled
+	if cfg.Database.AutoMigrate {
+		if err := database.MigrateUp(context.Background(), db); err != nil {
+			panic(err)
+		}
+	}
+
+	store := database.NewPostgresStore(db, utils.NewClient(cfg.Providers))
+
+	// `role <email> <role>` sets the role of a user and exits, e.g. to make
+	// the first admin
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		if err := setRole(store, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Serve until SIGTERM (or Ctrl-C), the requests are drained before the
+	// deferred calls close the pool and flush the spans
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if cfg.RateLimit.Backend == "memory" {
+		store.RateLimits, store.LoginAttempts = database.NewMemoryLimits()
+	}
+	if err := server.StartAndInitializeServer(ctx, cfg, store); err != nil {
+		panic(err)
+	}
+}
+
+// setRole sets the role of the user with the email, audited without an actor
+func setRole(store *database.Store, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: role <email> <%s|%s|%s>", models.RoleUser, models.RoleModerator, models.RoleAdmin)
+	}
+	email, role := args[0], args[1]
+	if role != models.RoleUser && role != models.RoleModerator && role != models.RoleAdmin {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+
+	ctx := context.Background()
+	user, err := store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	err = store.WithTx(ctx, func(tx *database.Store) error {
+		if err := tx.Users.UpdateRole(ctx, user.UserID, role); err != nil {
+			return err
+		}
+		return tx.Audit.Create(ctx, models.AuditEntry{Action: "user.role", TargetType: "user", TargetID: user.UserID,
+			Details: map[string]interface{}{"from": user.Role, "to": role}})
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", email, role)
+	return nil
+}
+
+func migrate(db *database.Database, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		return database.MigrateUp(context.Background(), db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+			steps = n
+		}
+		return database.MigrateDown(context.Background(), db, steps)
+	case "status":
+		migrations, applied, err := database.MigrationStatus(context.Background(), db)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			state := "pending"
+			if applied[migration.Version] {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", migration.Version, migration.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command: %s (expected up, down or status)", command)
+	}
 }
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Registry holds the metrics of the API, along with the Go runtime and
+// process ones
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served, by route and status.",
+	}, []string{"method", "route", "status"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to serve the HTTP requests, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	ProviderRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "provider_requests_total",
+		Help: "Calls to the external providers, by outcome (ok or error).",
+	}, []string{"provider", "outcome"})
+	ProviderRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "provider_request_duration_seconds",
+		Help:    "Time taken by the calls to the external providers.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Cache lookups, by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limited_requests_total",
+		Help: "Requests rejected by a rate limit or a login lockout, by limit.",
+	}, []string{"limit"})
+
+	TripsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "trips_created_total",
+		Help: "Trips recorded by the users.",
+	})
+	UsersRegistered = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "users_registered_total",
+		Help: "Accounts created.",
+	})
+	CarbonRecorded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "carbon_recorded_kg_total",
+		Help: "Carbon impact of the recorded trips, in kg of CO2 equivalent.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPRequestDuration,
+		ProviderRequests, ProviderRequestDuration,
+		CacheRequests,
+		RateLimited,
+		TripsCreated, UsersRegistered, CarbonRecorded,
+	)
+}
+
+// CacheLookup counts a lookup in the named cache
+func CacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	CacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -2,36 +2,166 @@ package models
 
 import "time"
 
+// Roles of the users, each one has the permissions of the previous ones
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Units of the distances and masses sent to and by the user
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+)
+
 // User represents the Users table
 type User struct {
-	UserID       int       `json:"user_id" db:"user_id"`
-	Email        string    `json:"email" db:"email"`
-	Username     string    `json:"username" db:"username"`
-	PasswordHash string    `json:"password_hash" db:"password_hash"`
-	GoogleID     *string   `json:"google_id,omitempty" db:"google_id"`
-	GithubID     *string   `json:"github_id,omitempty" db:"github_id"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	UserID   int    `json:"user_id" db:"user_id"`
+	Email    string `json:"email" db:"email"`
+	Username string `json:"username" db:"username"`
+	// PasswordHash is never sent to the clients
+	PasswordHash   string  `json:"-" db:"password_hash"`
+	GoogleID       *string `json:"google_id,omitempty" db:"google_id"`
+	GithubID       *string `json:"github_id,omitempty" db:"github_id"`
+	BaselineModeID *int    `json:"baseline_mode_id,omitempty" db:"baseline_mode_id"`
+	Role           string  `json:"role" db:"role"`
+	// Language of the messages, nil to follow the Accept-Language header
+	Language *string `json:"language,omitempty" db:"language"`
+	Units    string  `json:"units" db:"units"`
+	// Timezone is the IANA name of the time zone of the user, e.g. Europe/Paris
+	Timezone string `json:"timezone" db:"timezone"`
+	// DefaultModeID is the mode preselected for the new trips
+	DefaultModeID *int `json:"default_mode_id,omitempty" db:"default_mode_id"`
+	// AvatarKey is the key of the avatar in the blob storage
+	AvatarKey *string `json:"-" db:"avatar_key"`
+	// DisabledAt is set when a moderator disabled the account
+	DisabledAt *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Preferences are the settings of the user, a part of the Users table
+type Preferences struct {
+	Units    string  `json:"units"`
+	Timezone string  `json:"timezone"`
+	Language *string `json:"language"`
+	// DefaultModeID is the mode preselected for the new trips and
+	// BaselineModeID the vehicle the savings are computed against
+	DefaultModeID  *int `json:"default_mode_id"`
+	BaselineModeID *int `json:"baseline_mode_id"`
+}
+
+// Preferences returns the settings of the user
+func (u User) Preferences() Preferences {
+	return Preferences{
+		Units:          u.Units,
+		Timezone:       u.Timezone,
+		Language:       u.Language,
+		DefaultModeID:  u.DefaultModeID,
+		BaselineModeID: u.BaselineModeID,
+	}
 }
 
+// Location returns the time zone of the user, UTC when it is unknown
+func (u User) Location() *time.Location {
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// EmailChange represents the EmailChanges table, the email a user changes to
+// until it is verified
+type EmailChange struct {
+	UserID int    `json:"user_id" db:"user_id"`
+	Email  string `json:"email" db:"email"`
+	// TokenHash is the SHA-256 of the token sent to the new email
+	TokenHash string    `json:"-" db:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// ReportJob represents the ReportJobs table, the generation of the report of
+// a user for a period, whose files are in the blob storage once ready
+type ReportJob struct {
+	UserID      int        `json:"-" db:"user_id"`
+	Period      string     `json:"period" db:"period"`
+	Language    string     `json:"language" db:"language"`
+	Status      string     `json:"status" db:"status"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	RequestedAt time.Time  `json:"requested_at" db:"requested_at"`
+	GeneratedAt *time.Time `json:"generated_at,omitempty" db:"generated_at"`
+}
+
+// AccountDeletion represents the AccountDeletions table, the deletion of an
+// account asked by its user
+type AccountDeletion struct {
+	UserID int `json:"user_id" db:"user_id"`
+	// TokenHash is the SHA-256 of the token confirming the deletion, sent to
+	// the email of the user
+	TokenHash      string    `json:"-" db:"token_hash"`
+	TokenExpiresAt time.Time `json:"-" db:"token_expires_at"`
+	// ScheduledAt is when the account is deleted, nil until confirmed
+	ScheduledAt *time.Time `json:"scheduled_at" db:"scheduled_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+// UserData is everything stored about a user, exported on their request
+type UserData struct {
+	User            User                     `json:"user"`
+	Preferences     Preferences              `json:"preferences"`
+	EmailChange     *EmailChange             `json:"email_change,omitempty"`
+	AccountDeletion *AccountDeletion         `json:"account_deletion,omitempty"`
+	Budget          *CarbonBudget            `json:"budget,omitempty"`
+	Trips           []Trip                   `json:"trips"`
+	Notifications   []Notification           `json:"notifications"`
+	Participations  []ChallengeParticipation `json:"participations"`
+	Recommendations []Recommendation         `json:"recommendations"`
+}
+
+// Categories of the transportation modes
+const (
+	ModeCategoryActive  = "active"
+	ModeCategoryPublic  = "public"
+	ModeCategoryPrivate = "private"
+	ModeCategoryAir     = "air"
+)
+
 // TransportationMode represents the TransportationModes table
 type TransportationMode struct {
 	ModeID      int     `json:"mode_id" db:"mode_id"`
 	ModeName    string  `json:"mode_name" db:"mode_name"`
 	Description *string `json:"description,omitempty" db:"description"`
+	Category    string  `json:"category" db:"category"`
+	Icon        *string `json:"icon,omitempty" db:"icon"`
+	// EmissionFactorKgPerKm prices the trips when Impact CO₂ has no data for
+	// the mode
+	EmissionFactorKgPerKm *float64 `json:"emission_factor_kg_per_km,omitempty" db:"emission_factor_kg_per_km"`
+	// ImpactCO2ID is the transport ID of the mode at Impact CO₂, nil when the
+	// mode is not mapped to it
+	ImpactCO2ID *int `json:"impact_co2_id,omitempty" db:"impact_co2_id"`
+	// Enabled is false for the modes that can no longer be used for new trips
+	Enabled bool `json:"enabled" db:"enabled"`
+	// Names and Descriptions hold the name and the description of the mode
+	// by language, e.g. "fr"
+	Names        map[string]string `json:"names,omitempty" db:"names"`
+	Descriptions map[string]string `json:"descriptions,omitempty" db:"descriptions"`
 }
 
 // Trip represents the Trips table
 type Trip struct {
-	TripID         int       `json:"trip_id" db:"trip_id"`
-	UserID         int       `json:"user_id" db:"user_id"`
-	StartAddress   *string   `json:"start_address,omitempty" db:"start_address"`
-	EndAddress     *string   `json:"end_address,omitempty" db:"end_address"`
-	DistanceKm     *float64  `json:"distance_km,omitempty" db:"distance_km"`
-	ModeID         int       `json:"mode_id" db:"mode_id"`
-	CarbonImpactKg *float64  `json:"carbon_impact_kg,omitempty" db:"carbon_impact_kg"`
-	TripDate       time.Time `json:"trip_date" db:"trip_date"`
-	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	TripID           int       `json:"trip_id" db:"trip_id"`
+	UserID           int       `json:"user_id" db:"user_id"`
+	StartAddress     *string   `json:"start_address,omitempty" db:"start_address"`
+	EndAddress       *string   `json:"end_address,omitempty" db:"end_address"`
+	DistanceKm       *float64  `json:"distance_km,omitempty" db:"distance_km"`
+	ModeID           int       `json:"mode_id" db:"mode_id"`
+	CarbonImpactKg   *float64  `json:"carbon_impact_kg,omitempty" db:"carbon_impact_kg"`
+	BaselineImpactKg *float64  `json:"baseline_impact_kg,omitempty" db:"baseline_impact_kg"`
+	TripDate         time.Time `json:"trip_date" db:"trip_date"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
 }
 
 // Challenge represents the Challenges table
@@ -66,4 +196,99 @@ type TripsByMode struct {
 	TotalTrips    int     `json:"total_trips"`
 	TotalImpact   float64 `json:"total_impact"`
 	TotalDistance float64 `json:"total_distance"`
+	TotalAvoided  float64 `json:"total_avoided"`
+}
+
+// Savings compares the emitted carbon with the carbon of the baseline mode
+type Savings struct {
+	Period        string  `json:"period,omitempty"`
+	TotalImpact   float64 `json:"total_impact"`
+	TotalBaseline float64 `json:"total_baseline"`
+	TotalAvoided  float64 `json:"total_avoided"`
+}
+
+type ModeComparison struct {
+	ModeID          int      `json:"mode_id"`
+	ModeName        string   `json:"mode_name"`
+	CarbonImpactKg  float64  `json:"carbon_impact_kg"`
+	DurationMinutes *float64 `json:"duration_minutes,omitempty"`
+}
+
+// CarbonBudget represents the CarbonBudgets table
+type CarbonBudget struct {
+	BudgetID           int        `json:"budget_id" db:"budget_id"`
+	UserID             int        `json:"user_id" db:"user_id"`
+	Period             string     `json:"period" db:"period"`
+	LimitKg            float64    `json:"limit_kg" db:"limit_kg"`
+	LastAlertThreshold int        `json:"last_alert_threshold" db:"last_alert_threshold"`
+	AlertPeriodStart   *time.Time `json:"alert_period_start,omitempty" db:"alert_period_start"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// BudgetStatus is the consumption of a carbon budget over its current period
+type BudgetStatus struct {
+	Period            string    `json:"period"`
+	PeriodStart       time.Time `json:"period_start"`
+	PeriodEnd         time.Time `json:"period_end"`
+	LimitKg           float64   `json:"limit_kg"`
+	ConsumedKg        float64   `json:"consumed_kg"`
+	RemainingKg       float64   `json:"remaining_kg"`
+	UsedPercent       float64   `json:"used_percent"`
+	ProjectedKg       float64   `json:"projected_kg"`
+	ProjectedPercent  float64   `json:"projected_percent"`
+	ThresholdsReached []int     `json:"thresholds_reached"`
+}
+
+// Notification represents the Notifications table
+type Notification struct {
+	NotificationID int        `json:"notification_id" db:"notification_id"`
+	UserID         int        `json:"user_id" db:"user_id"`
+	Type           string     `json:"type" db:"type"`
+	Message        string     `json:"message" db:"message"`
+	ReadAt         *time.Time `json:"read_at,omitempty" db:"read_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// MonthlyEmission is the carbon impact of a calendar month
+type MonthlyEmission struct {
+	Month    string  `json:"month"`
+	ImpactKg float64 `json:"impact_kg"`
+}
+
+// ModeShareDrift compares the share of a mode in two consecutive windows
+type ModeShareDrift struct {
+	ModeID        int     `json:"mode_id"`
+	PreviousShare float64 `json:"previous_share"`
+	CurrentShare  float64 `json:"current_share"`
+	Drift         float64 `json:"drift"`
+}
+
+// EmissionTrend holds trend statistics over the monthly history
+type EmissionTrend struct {
+	MonthOverMonthChange *float64          `json:"month_over_month_change"`
+	MovingAverage        []MonthlyEmission `json:"moving_average"`
+	ModeShareDrift       []ModeShareDrift  `json:"mode_share_drift"`
+}
+
+// EmissionForecast is the projection of the user's future emissions
+type EmissionForecast struct {
+	Method   string            `json:"method"`
+	History  []MonthlyEmission `json:"history"`
+	Forecast []MonthlyEmission `json:"forecast"`
+	Trend    EmissionTrend     `json:"trend"`
+}
+
+// AuditEntry represents the AuditLog table, an action of a moderator or an
+// admin
+type AuditEntry struct {
+	AuditID int `json:"audit_id" db:"audit_id"`
+	// ActorID is nil for the actions run from the command line
+	ActorID    *int                   `json:"actor_id,omitempty" db:"actor_id"`
+	Action     string                 `json:"action" db:"action"`
+	TargetType string                 `json:"target_type" db:"target_type"`
+	TargetID   int                    `json:"target_id" db:"target_id"`
+	Details    map[string]interface{} `json:"details,omitempty" db:"details"`
+	RequestID  string                 `json:"request_id,omitempty" db:"request_id"`
+	CreatedAt  time.Time              `json:"created_at" db:"created_at"`
 }
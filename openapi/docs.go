@@ -0,0 +1,9 @@
+package openapi
+
+import _ "embed"
+
+// DocsHTML is a self-contained page rendering the document served at
+// /openapi.json, so the docs work without any external asset
+//
+//go:embed docs.html
+var DocsHTML []byte
@@ -0,0 +1,202 @@
+package reports
+
+import (
+	"API/database"
+	"API/models"
+	"API/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportTimeout bounds the generation of a single report
+const reportTimeout = 2 * time.Minute
+
+// finishTimeout bounds the saving of the status of a report, which must not
+// share the time that its generation may have used up
+const finishTimeout = 5 * time.Second
+
+const (
+	StatusPending = "pending"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+// errorGenerating is the error of a failed report sent to the user, the cause
+// stays in the logs
+const errorGenerating = "the report could not be generated"
+
+// Job is a report generation for a user and a period
+type Job = models.ReportJob
+
+// Generator renders reports in the background and stores them in the blob
+// storage, with their status in the database, so that any instance serves them
+type Generator struct {
+	store *database.Store
+	blobs storage.Blobs
+	mu    sync.Mutex
+	queue chan Job
+	wg    sync.WaitGroup
+	// stopped is set by Stop, the queue is closed
+	stopped bool
+}
+
+// NewGenerator starts a generator with the given number of workers
+func NewGenerator(store *database.Store, blobs storage.Blobs, workers int) *Generator {
+	g := &Generator{
+		store: store,
+		blobs: blobs,
+		queue: make(chan Job, 100),
+	}
+	for i := 0; i < workers; i++ {
+		g.wg.Add(1)
+		go g.work()
+	}
+	return g
+}
+
+// blobKey is the key of the report file in format, html or pdf
+func blobKey(userID int, period, format string) string {
+	return fmt.Sprintf("reports/%d/%s.%s", userID, period, format)
+}
+
+// Request queues the generation of a report in the language lang unless one
+// is already pending. A ready or failed report is generated again, as is a
+// pending one older than reportTimeout, whose instance stopped meanwhile.
+func (g *Generator) Request(ctx context.Context, userID int, period, lang string) (Job, error) {
+	// period and lang may point into the buffer of a request, reused once it
+	// is answered, while the job outlives it
+	period, lang = strings.Clone(period), strings.Clone(lang)
+	// the database keeps the microseconds
+	now := time.Now().Truncate(time.Microsecond)
+	job, started, err := g.store.ReportJobs.Start(ctx, Job{UserID: userID, Period: period, Language: lang, Status: StatusPending, RequestedAt: now},
+		now.Add(-reportTimeout))
+	if err != nil {
+		return Job{}, err
+	}
+	if !started {
+		return *job, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return g.fail(ctx, *job, "the server is shutting down, try again later")
+	}
+	select {
+	case g.queue <- *job:
+	default:
+		return g.fail(ctx, *job, "too many reports are being generated, try again later")
+	}
+	return *job, nil
+}
+
+func (g *Generator) fail(ctx context.Context, job Job, reason string) (Job, error) {
+	job.Status = StatusFailed
+	job.Error = reason
+	return job, g.store.ReportJobs.Finish(ctx, job)
+}
+
+// Get returns the report job of the user for the period, false if there is
+// none or if it is a stale pending one
+func (g *Generator) Get(ctx context.Context, userID int, period string) (Job, bool, error) {
+	job, err := g.store.ReportJobs.Get(ctx, userID, period)
+	if errors.Is(err, database.ErrReportNotFound) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	if job.Status == StatusPending && time.Since(job.RequestedAt) > reportTimeout {
+		return Job{}, false, nil
+	}
+	return *job, true, nil
+}
+
+// Open opens the file of the ready report in format, html or pdf, to be
+// closed by the caller
+func (g *Generator) Open(ctx context.Context, userID int, period, format string) (io.ReadCloser, error) {
+	return g.blobs.Get(ctx, blobKey(userID, period, format))
+}
+
+// Delete removes the files of the report of the user for the period
+func (g *Generator) Delete(ctx context.Context, userID int, period string) error {
+	for _, format := range []string{"html", "pdf"} {
+		if err := g.blobs.Delete(ctx, blobKey(userID, period, format)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops accepting reports and waits for the queued ones to be generated,
+// or for ctx to be done
+func (g *Generator) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	if !g.stopped {
+		g.stopped = true
+		close(g.queue)
+	}
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *Generator) work() {
+	defer g.wg.Done()
+	for job := range g.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+		err := g.generate(ctx, job.UserID, job.Period, job.Language)
+		cancel()
+		if err != nil {
+			slog.Error("Error generating report", "user_id", job.UserID, "period", job.Period, "error", err)
+			job.Status = StatusFailed
+			job.Error = errorGenerating
+		} else {
+			job.Status = StatusReady
+			now := time.Now()
+			job.GeneratedAt = &now
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), finishTimeout)
+		if err := g.store.ReportJobs.Finish(ctx, job); err != nil {
+			slog.Error("Error saving report status", "user_id", job.UserID, "period", job.Period, "error", err)
+		}
+		cancel()
+	}
+}
+
+// generate renders the report and stores its HTML and PDF files
+func (g *Generator) generate(ctx context.Context, userID int, period, lang string) error {
+	report, err := Build(ctx, g.store, userID, period, lang)
+	if err != nil {
+		return err
+	}
+	html, err := RenderHTML(report)
+	if err != nil {
+		return err
+	}
+	pdf, err := RenderPDF(report)
+	if err != nil {
+		return err
+	}
+	if err := g.blobs.Put(ctx, blobKey(userID, period, "html"), bytes.NewReader(html)); err != nil {
+		return err
+	}
+	return g.blobs.Put(ctx, blobKey(userID, period, "pdf"), bytes.NewReader(pdf))
+}
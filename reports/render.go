@@ -0,0 +1,214 @@
+package reports
+
+import (
+	"API/i18n"
+	"bytes"
+	"embed"
+	"fmt"
+	"github.com/go-pdf/fpdf"
+	"html/template"
+	"time"
+)
+
+//go:embed templates/report.html
+var templateFS embed.FS
+
+// htmlTemplate is cloned with the functions of the language of each report
+var htmlTemplate = template.Must(template.New("report.html").Funcs((&Report{Language: i18n.Default}).funcs()).
+	ParseFS(templateFS, "templates/report.html"))
+
+// chart dimensions shared by the HTML (pixels) and PDF (millimetres) renderers
+const (
+	chartWidth  = 600.0
+	chartHeight = 200.0
+	labelHeight = 14.0
+)
+
+type bar struct {
+	X, Y, Width, Height float64
+	LabelX              float64
+	Label               string
+}
+
+type chart struct {
+	Width, Height float64
+	Bars          []bar
+}
+
+// Title returns the heading of the report, e.g. "2024 in review" or "May 2024 in review"
+func (r *Report) Title() string {
+	if r.To.Sub(r.From) > 31*24*time.Hour {
+		return r.t("%d in review", r.From.Year())
+	}
+	return r.t("%s in review", i18n.FormatMonth(r.Language, r.From))
+}
+
+// t translates a message of the report in its language
+func (r *Report) t(message string, args ...interface{}) string {
+	return i18n.T(r.Language, message, args...)
+}
+
+// number formats a figure of the report with the separators of its language
+func (r *Report) number(value float64, decimals int) string {
+	return i18n.FormatNumber(r.Language, value, decimals)
+}
+
+func (r *Report) date(t time.Time) string {
+	return i18n.FormatDate(r.Language, t)
+}
+
+// month formats a YYYY-MM month, e.g. May 2024
+func (r *Report) month(month string) string {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return month
+	}
+	return i18n.FormatMonth(r.Language, t)
+}
+
+// funcs are the functions of the HTML template
+func (r *Report) funcs() template.FuncMap {
+	return template.FuncMap{
+		"t":      r.t,
+		"number": r.number,
+		"date":   r.date,
+		"month":  r.month,
+	}
+}
+
+// monthlyChart lays out one bar per month scaled to the given area
+func (r *Report) monthlyChart(width, height float64) chart {
+	c := chart{Width: width, Height: height + labelHeight}
+	if len(r.Monthly) == 0 {
+		return c
+	}
+	var max float64
+	for _, month := range r.Monthly {
+		if month.ImpactKg > max {
+			max = month.ImpactKg
+		}
+	}
+	slot := width / float64(len(r.Monthly))
+	for i, month := range r.Monthly {
+		h := 0.0
+		if max > 0 {
+			h = month.ImpactKg / max * height
+		}
+		c.Bars = append(c.Bars, bar{
+			X:      float64(i)*slot + slot*0.1,
+			Y:      height - h,
+			Width:  slot * 0.8,
+			Height: h,
+			LabelX: float64(i)*slot + slot/2,
+			Label:  month.Month[5:],
+		})
+	}
+	return c
+}
+
+// RenderHTML renders the report as a standalone HTML page
+func RenderHTML(r *Report) ([]byte, error) {
+	tmpl, err := htmlTemplate.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render HTML report: %w", err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Funcs(r.funcs()).Execute(&buf, map[string]interface{}{
+		"Title":  r.Title(),
+		"Report": r,
+		"Chart":  r.monthlyChart(chartWidth, chartHeight),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render HTML report: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderPDF renders the report as an A4 PDF document
+func RenderPDF(r *Report) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle(r.Title(), true)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.SetTextColor(47, 125, 50)
+	pdf.CellFormat(0, 12, tr(r.Title()), "", 1, "", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.SetTextColor(31, 41, 51)
+	pdf.CellFormat(0, 7, tr(r.t("%s - %s to %s", r.Username, r.date(r.From), r.date(r.To.AddDate(0, 0, -1)))), "", 1, "", false, 0, "")
+	pdf.Ln(4)
+
+	// key figures
+	pdf.SetFillColor(240, 247, 240)
+	figures := []string{
+		r.t("%s kg CO2 emitted", r.number(r.TotalImpactKg, 1)),
+		r.t("%s kg CO2 avoided", r.number(r.Savings.TotalAvoided, 1)),
+		r.t("%d trips, %s km", r.TotalTrips, r.number(r.TotalDistanceKm, 0)),
+	}
+	for i, figure := range figures {
+		ln := 0
+		if i == len(figures)-1 {
+			ln = 1
+		}
+		pdf.CellFormat(60, 12, tr(figure), "", ln, "C", true, 0, "")
+	}
+	if r.BestMonth != nil {
+		pdf.Ln(3)
+		pdf.CellFormat(0, 7, tr(r.t("Best month: %s with %s kg CO2", r.month(r.BestMonth.Month), r.number(r.BestMonth.ImpactKg, 1))), "", 1, "", false, 0, "")
+	}
+
+	// monthly chart
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 9, tr(r.t("Monthly emissions")), "", 1, "", false, 0, "")
+	left, top := pdf.GetXY()
+	c := r.monthlyChart(180, 50)
+	pdf.SetFillColor(76, 175, 80)
+	pdf.SetFont("Helvetica", "", 8)
+	for _, b := range c.Bars {
+		if b.Height > 0 {
+			pdf.Rect(left+b.X, top+b.Y, b.Width, b.Height, "F")
+		}
+		pdf.Text(left+b.LabelX-2, top+50+5, b.Label)
+	}
+	pdf.SetXY(left, top+c.Height)
+
+	// per mode breakdown
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 9, tr(r.t("By transportation mode")), "", 1, "", false, 0, "")
+	pdf.SetFont("Helvetica", "B", 10)
+	for _, header := range []string{"Mode", "Trips", "Distance (km)", "CO2 (kg)", "Avoided (kg)"} {
+		pdf.CellFormat(36, 7, tr(r.t(header)), "B", 0, "", false, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 10)
+	for _, mode := range r.ByMode {
+		pdf.CellFormat(36, 7, tr(mode.ModeName), "", 0, "", false, 0, "")
+		pdf.CellFormat(36, 7, fmt.Sprintf("%d", mode.TotalTrips), "", 0, "", false, 0, "")
+		pdf.CellFormat(36, 7, tr(r.number(mode.TotalDistance, 1)), "", 0, "", false, 0, "")
+		pdf.CellFormat(36, 7, tr(r.number(mode.TotalImpact, 1)), "", 0, "", false, 0, "")
+		pdf.CellFormat(36, 7, tr(r.number(mode.TotalAvoided, 1)), "", 1, "", false, 0, "")
+	}
+
+	if len(r.TopRoutes) > 0 {
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(0, 9, tr(r.t("Top routes")), "", 1, "", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		for _, route := range r.TopRoutes {
+			line := r.t("%s -> %s: %d trips, %s kg CO2", route.StartAddress, route.EndAddress, route.Trips, r.number(route.TotalImpactKg, 1))
+			pdf.MultiCell(0, 6, tr(line), "", "", false)
+		}
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.CellFormat(0, 6, tr(r.t("Generated %s", r.date(r.GeneratedAt))), "", 1, "", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to render PDF report: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,163 @@
+package reports
+
+import (
+	"API/database"
+	"API/i18n"
+	"API/models"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Report holds the "year in review" figures of a user over a month or a year
+type Report struct {
+	UserID          int                      `json:"user_id"`
+	Username        string                   `json:"username"`
+	Period          string                   `json:"period"`
+	Language        string                   `json:"language"`
+	From            time.Time                `json:"from"`
+	To              time.Time                `json:"to"`
+	TotalTrips      int                      `json:"total_trips"`
+	TotalDistanceKm float64                  `json:"total_distance_km"`
+	TotalImpactKg   float64                  `json:"total_impact_kg"`
+	Savings         models.Savings           `json:"savings"`
+	ByMode          []ModeBreakdown          `json:"by_mode"`
+	TopRoutes       []Route                  `json:"top_routes"`
+	BestMonth       *models.MonthlyEmission  `json:"best_month,omitempty"`
+	Monthly         []models.MonthlyEmission `json:"monthly"`
+	GeneratedAt     time.Time                `json:"generated_at"`
+}
+
+// ModeBreakdown is the AggregateUserTripsByMode entry of a mode with its name
+type ModeBreakdown struct {
+	models.TripsByMode
+	ModeName string `json:"mode_name"`
+}
+
+// Route is a start and end address pair travelled by the user
+type Route struct {
+	StartAddress  string  `json:"start_address"`
+	EndAddress    string  `json:"end_address"`
+	Trips         int     `json:"trips"`
+	TotalImpactKg float64 `json:"total_impact_kg"`
+}
+
+const maxTopRoutes = 5
+
+// ParsePeriod parses a YYYY or YYYY-MM period and returns its bounds, at
+// midnight in loc
+func ParsePeriod(period string, loc *time.Location) (time.Time, time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01", period, loc); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	if t, err := time.ParseInLocation("2006", period, loc); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid period: %s", period)
+}
+
+// Build gathers the report figures of the user for the period, with the mode
+// names in the language lang and the trips bucketed in the time zone of the
+// user
+func Build(ctx context.Context, store *database.Store, userID int, period, lang string) (*Report, error) {
+	user, err := store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	loc := user.Location()
+	from, to, err := ParsePeriod(period, loc)
+	if err != nil {
+		return nil, err
+	}
+	trips, err := store.GetUserTrips(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	modes, err := store.Modes.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		UserID:      userID,
+		Username:    user.Username,
+		Period:      period,
+		Language:    lang,
+		From:        from,
+		To:          to,
+		ByMode:      []ModeBreakdown{},
+		TopRoutes:   []Route{},
+		Monthly:     []models.MonthlyEmission{},
+		GeneratedAt: time.Now(),
+	}
+
+	var periodTrips []models.Trip
+	for _, trip := range trips {
+		if !trip.TripDate.Before(from) && trip.TripDate.Before(to) {
+			periodTrips = append(periodTrips, trip)
+		}
+	}
+
+	aggregation, err := store.AggregateTripsByMode(ctx, periodTrips)
+	if err != nil {
+		return nil, err
+	}
+	modeNames := make(map[int]string)
+	for _, mode := range modes {
+		modeNames[mode.ModeID] = i18n.Pick(lang, mode.Names, mode.ModeName)
+	}
+	for _, entry := range aggregation {
+		report.ByMode = append(report.ByMode, ModeBreakdown{TripsByMode: entry, ModeName: modeNames[entry.ModeID]})
+	}
+	sort.Slice(report.ByMode, func(i, j int) bool {
+		return report.ByMode[i].TotalImpact > report.ByMode[j].TotalImpact
+	})
+
+	routes := make(map[[2]string]*Route)
+	monthly := make(map[string]float64)
+	for _, trip := range periodTrips {
+		report.TotalTrips++
+		report.TotalDistanceKm += *trip.DistanceKm
+		report.TotalImpactKg += *trip.CarbonImpactKg
+		avoided := database.TripAvoidedImpact(trip)
+		report.Savings.TotalImpact += *trip.CarbonImpactKg
+		report.Savings.TotalBaseline += *trip.CarbonImpactKg + avoided
+		report.Savings.TotalAvoided += avoided
+		monthly[trip.TripDate.In(loc).Format("2006-01")] += *trip.CarbonImpactKg
+
+		if trip.StartAddress != nil && trip.EndAddress != nil {
+			key := [2]string{*trip.StartAddress, *trip.EndAddress}
+			route, ok := routes[key]
+			if !ok {
+				route = &Route{StartAddress: key[0], EndAddress: key[1]}
+				routes[key] = route
+			}
+			route.Trips++
+			route.TotalImpactKg += *trip.CarbonImpactKg
+		}
+	}
+	report.Savings.Period = period
+
+	for _, route := range routes {
+		report.TopRoutes = append(report.TopRoutes, *route)
+	}
+	sort.Slice(report.TopRoutes, func(i, j int) bool {
+		return report.TopRoutes[i].Trips > report.TopRoutes[j].Trips
+	})
+	if len(report.TopRoutes) > maxTopRoutes {
+		report.TopRoutes = report.TopRoutes[:maxTopRoutes]
+	}
+
+	// every month of the period appears in the chart, the best month is the lowest one with trips
+	for month := from; month.Before(to); month = month.AddDate(0, 1, 0) {
+		key := month.Format("2006-01")
+		entry := models.MonthlyEmission{Month: key, ImpactKg: monthly[key]}
+		report.Monthly = append(report.Monthly, entry)
+		if _, ok := monthly[key]; ok && (report.BestMonth == nil || entry.ImpactKg < report.BestMonth.ImpactKg) {
+			best := entry
+			report.BestMonth = &best
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"API/database"
+	"API/i18n"
+	"API/models"
+	"API/storage"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"log/slog"
+	"path"
+	"strconv"
+	"time"
+)
+
+// accountPurgeInterval is how often the accounts past their grace period are
+// deleted
+const accountPurgeInterval = time.Hour
+
+// exportHandler sends a ZIP of everything stored about the user: data.json
+// with all of it, a CSV file for each list and the avatar
+func (s *Server) exportHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	ctx := c.UserContext()
+	data, err := s.store.ExportUserData(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := writeExport(zw, data); err != nil {
+		return err
+	}
+	if data.User.AvatarKey != nil {
+		if err := s.exportAvatar(ctx, zw, *data.User.AvatarKey); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	c.Attachment(fmt.Sprintf("export-%s.zip", time.Now().Format("2006-01-02")))
+	return c.Send(buf.Bytes())
+}
+
+// writeExport writes the data of the user as JSON and CSV files
+func writeExport(zw *zip.Writer, data *models.UserData) error {
+	w, err := zw.Create("data.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return err
+	}
+
+	trips := [][]string{{"trip_id", "trip_date", "start_address", "end_address", "distance_km", "mode_id", "carbon_impact_kg", "baseline_impact_kg", "created_at"}}
+	for _, trip := range data.Trips {
+		trips = append(trips, []string{strconv.Itoa(trip.TripID), csvTime(trip.TripDate), csvString(trip.StartAddress), csvString(trip.EndAddress),
+			csvFloat(trip.DistanceKm), strconv.Itoa(trip.ModeID), csvFloat(trip.CarbonImpactKg), csvFloat(trip.BaselineImpactKg), csvTime(trip.CreatedAt)})
+	}
+	notifications := [][]string{{"notification_id", "type", "message", "read_at", "created_at"}}
+	for _, n := range data.Notifications {
+		readAt := ""
+		if n.ReadAt != nil {
+			readAt = csvTime(*n.ReadAt)
+		}
+		notifications = append(notifications, []string{strconv.Itoa(n.NotificationID), n.Type, n.Message, readAt, csvTime(n.CreatedAt)})
+	}
+	participations := [][]string{{"participation_id", "challenge_id", "progress", "completed"}}
+	for _, p := range data.Participations {
+		participations = append(participations, []string{strconv.Itoa(p.ParticipationID), strconv.Itoa(p.ChallengeID), csvFloat(p.Progress), strconv.FormatBool(p.Completed)})
+	}
+	recommendations := [][]string{{"recommendation_id", "message", "created_at"}}
+	for _, rec := range data.Recommendations {
+		recommendations = append(recommendations, []string{strconv.Itoa(rec.RecommendationID), rec.Message, csvTime(rec.CreatedAt)})
+	}
+
+	for _, file := range []struct {
+		name string
+		rows [][]string
+	}{
+		{"trips.csv", trips},
+		{"notifications.csv", notifications},
+		{"participations.csv", participations},
+		{"recommendations.csv", recommendations},
+	} {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err := csv.NewWriter(w).WriteAll(file.rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) exportAvatar(ctx context.Context, zw *zip.Writer, key string) error {
+	avatar, err := s.blobs.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer avatar.Close()
+	w, err := zw.Create("avatar" + path.Ext(key))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, avatar)
+	return err
+}
+
+func csvString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func csvFloat(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+func csvTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+// deletionRequest is the body of POST /user/deletion
+type deletionRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// requestDeletionHandler sends the token confirming the deletion of the
+// account to the email of the user
+func (s *Server) requestDeletionHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req deletionRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	ctx := c.UserContext()
+	token, err := s.store.RequestAccountDeletion(ctx, userID, req.Password)
+	if err != nil {
+		return err
+	}
+	user, err := s.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	lang := language(c)
+	subject := i18n.T(lang, "Confirm the deletion of your account")
+	body := i18n.T(lang, "Hello %s,\n\nConfirm the deletion of your account with this code, valid for %d hours:\n\n%s\n\nYour account and all your data will then be deleted after %d days, until when you can cancel the deletion. If you did not ask for it, change your password.",
+		user.Username, int(database.DeletionTokenTTL.Hours()), token, int(s.cfg.Auth.DeletionGracePeriod.Hours()/24))
+	if err := s.mailer.Send(ctx, user.Email, subject, body); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "confirmation email sent"})
+}
+
+// confirmDeletionRequest is the body of POST /user/deletion/confirm, with the
+// token sent to the email of the user
+type confirmDeletionRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (s *Server) confirmDeletionHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req confirmDeletionRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	deletion, err := s.store.ConfirmAccountDeletion(c.UserContext(), userID, req.Token, s.cfg.Auth.DeletionGracePeriod)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "account deletion scheduled", "deletion": deletion})
+}
+
+func (s *Server) deletionHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	deletion, err := s.store.AccountDeletions.GetByUser(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"deletion": deletion})
+}
+
+func (s *Server) cancelDeletionHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	// the deletion stays locked so that an account purged meanwhile is not
+	// reported as canceled
+	ctx := c.UserContext()
+	err := s.store.WithTx(ctx, func(tx *database.Store) error {
+		if _, err := tx.AccountDeletions.GetByUserForUpdate(ctx, userID); err != nil {
+			return err
+		}
+		return tx.AccountDeletions.DeleteByUser(ctx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "account deletion canceled"})
+}
+
+// purgeAccounts deletes the accounts whose deletion is due, each one in its
+// own transaction with its entry of the audit log
+func (s *Server) purgeAccounts(ctx context.Context) {
+	deletions, err := s.store.AccountDeletions.Due(ctx, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing the account deletions", "error", err)
+		return
+	}
+	for _, deletion := range deletions {
+		entry := models.AuditEntry{Action: auditUserPurge, TargetType: "user", TargetID: deletion.UserID,
+			Details: map[string]interface{}{"scheduled_at": deletion.ScheduledAt}}
+		err := s.deleteAccount(ctx, deletion.UserID, func(tx *database.Store) error {
+			return tx.Audit.Create(ctx, entry)
+		})
+		// another instance may have purged it first
+		if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+			slog.ErrorContext(ctx, "Error deleting the account", "user_id", deletion.UserID, "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Account deleted", "user_id", deletion.UserID)
+	}
+}
+
+// deleteAccount deletes the user with all their data, their avatar and their
+// reports, then runs record in the same transaction, e.g. to audit the
+// deletion
+func (s *Server) deleteAccount(ctx context.Context, userID int, record func(tx *database.Store) error) error {
+	var deleted *deletedAccount
+	err := s.store.WithTx(ctx, func(tx *database.Store) error {
+		var err error
+		if deleted, err = deleteUserData(ctx, tx, userID); err != nil {
+			return err
+		}
+		return record(tx)
+	})
+	if err != nil {
+		return err
+	}
+	s.cleanUpAccount(ctx, deleted)
+	return nil
+}
+
+// deletedAccount is what is left to clean up once the deletion of an account
+// is committed
+type deletedAccount struct {
+	user    *models.User
+	reports []models.ReportJob
+}
+
+// deleteUserData deletes the rows of the user in the transaction tx
+func deleteUserData(ctx context.Context, tx *database.Store, userID int) (*deletedAccount, error) {
+	reports, err := tx.ReportJobs.GetByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := tx.DeleteUserData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &deletedAccount{user: user, reports: reports}, nil
+}
+
+// cleanUpAccount deletes the avatar and the report files of the deleted
+// account and forgets the failed logins of its email
+func (s *Server) cleanUpAccount(ctx context.Context, deleted *deletedAccount) {
+	if deleted.user.AvatarKey != nil {
+		s.deleteBlob(ctx, *deleted.user.AvatarKey)
+	}
+	for _, report := range deleted.reports {
+		if err := s.reports.Delete(ctx, deleted.user.UserID, report.Period); err != nil {
+			slog.WarnContext(ctx, "Error deleting report", "period", report.Period, "error", err)
+		}
+	}
+	if err := s.store.LoginAttempts.ResetPrefix(ctx, loginKeyPrefix(deleted.user.Email)); err != nil {
+		slog.ErrorContext(ctx, "Error resetting the login attempts", "error", err)
+	}
+}
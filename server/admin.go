@@ -0,0 +1,439 @@
+package server
+
+import (
+	"API/database"
+	"API/models"
+	"API/utils"
+	"github.com/gofiber/fiber/v2"
+	"log/slog"
+	"strconv"
+)
+
+// Actions of the audit log
+const (
+	auditUserDisable     = "user.disable"
+	auditUserEnable      = "user.enable"
+	auditUserRole        = "user.role"
+	auditUserDelete      = "user.delete"
+	auditUserPurge       = "user.purge"
+	auditModeCreate      = "mode.create"
+	auditModeUpdate      = "mode.update"
+	auditModeDelete      = "mode.delete"
+	auditChallengeCreate = "challenge.create"
+	auditChallengeUpdate = "challenge.update"
+	auditChallengeDelete = "challenge.delete"
+)
+
+// adminPageSize is the page size of the admin lists when no limit is given
+const adminPageSize = 50
+
+// audited runs action and records entry in the audit log, in the same
+// transaction so that no action is left unrecorded. action may set the
+// target ID of entry, e.g. once the target is created.
+func (s *Server) audited(c *fiber.Ctx, entry *models.AuditEntry, action func(tx *database.Store) error) error {
+	actorID := int(c.Locals("user").(float64))
+	entry.ActorID = &actorID
+	entry.RequestID = requestID(c)
+
+	ctx := c.UserContext()
+	err := s.store.WithTx(ctx, func(tx *database.Store) error {
+		if err := action(tx); err != nil {
+			return err
+		}
+		return tx.Audit.Create(ctx, *entry)
+	})
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Admin action", "action", entry.Action, "actor_id", actorID,
+		"target_type", entry.TargetType, "target_id", entry.TargetID)
+	return nil
+}
+
+// idParam parses a numeric path parameter
+func idParam(c *fiber.Ctx, name string) (int, error) {
+	id, err := strconv.Atoi(c.Params(name))
+	if err != nil || id <= 0 {
+		return 0, invalidParameter("invalid %s", name)
+	}
+	return id, nil
+}
+
+// managedUser returns the user of the :user_id parameter, if the signed-in
+// user may manage it: not themselves, and only users with a lower role
+func (s *Server) managedUser(c *fiber.Ctx) (*models.User, error) {
+	userID, err := idParam(c, "user_id")
+	if err != nil {
+		return nil, err
+	}
+	if userID == int(c.Locals("user").(float64)) {
+		return nil, newError(fiber.StatusForbidden, "forbidden", "you cannot manage your own account")
+	}
+	user, err := s.store.Users.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := c.Locals("role").(string)
+	if hasRole(user.Role, role) {
+		return nil, newError(fiber.StatusForbidden, "forbidden", "the user has the same role as you or a higher one")
+	}
+	return user, nil
+}
+
+// adminUsersQuery is the query of GET /admin/users
+type adminUsersQuery struct {
+	Q        string `query:"q" validate:"max=255"`
+	Role     string `query:"role" validate:"omitempty,oneof=user moderator admin"`
+	Disabled *bool  `query:"disabled"`
+	Limit    int    `query:"limit" validate:"gte=0,lte=200"`
+	Offset   int    `query:"offset" validate:"gte=0"`
+}
+
+func (s *Server) adminUsersHandler(c *fiber.Ctx) error {
+	var req adminUsersQuery
+	if err := parseQuery(c, &req); err != nil {
+		return err
+	}
+	if req.Limit == 0 {
+		req.Limit = adminPageSize
+	}
+
+	users, total, err := s.store.Users.Search(c.UserContext(), database.UserFilter{
+		Query:    req.Q,
+		Role:     req.Role,
+		Disabled: req.Disabled,
+		Limit:    req.Limit,
+		Offset:   req.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"users": users, "total": total})
+}
+
+func (s *Server) adminUserHandler(c *fiber.Ctx) error {
+	userID, err := idParam(c, "user_id")
+	if err != nil {
+		return err
+	}
+
+	user, err := s.store.Users.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"user": user})
+}
+
+// adminDisableHandler disables the account of POST /admin/users/:user_id/disable,
+// or enables it again for /enable
+func (s *Server) adminDisableHandler(disabled bool) fiber.Handler {
+	action, message := auditUserEnable, "user enabled"
+	if disabled {
+		action, message = auditUserDisable, "user disabled"
+	}
+
+	return func(c *fiber.Ctx) error {
+		user, err := s.managedUser(c)
+		if err != nil {
+			return err
+		}
+
+		entry := models.AuditEntry{Action: action, TargetType: "user", TargetID: user.UserID}
+		err = s.audited(c, &entry, func(tx *database.Store) error {
+			return tx.Users.SetDisabled(c.UserContext(), user.UserID, disabled)
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"message": message})
+	}
+}
+
+// roleRequest is the body of PUT /admin/users/:user_id/role
+type roleRequest struct {
+	Role string `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+func (s *Server) adminRoleHandler(c *fiber.Ctx) error {
+	var req roleRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	user, err := s.managedUser(c)
+	if err != nil {
+		return err
+	}
+
+	entry := models.AuditEntry{Action: auditUserRole, TargetType: "user", TargetID: user.UserID,
+		Details: map[string]interface{}{"from": user.Role, "to": req.Role}}
+	err = s.audited(c, &entry, func(tx *database.Store) error {
+		return tx.Users.UpdateRole(c.UserContext(), user.UserID, req.Role)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "role updated", "role": req.Role})
+}
+
+func (s *Server) adminDeleteUserHandler(c *fiber.Ctx) error {
+	user, err := s.managedUser(c)
+	if err != nil {
+		return err
+	}
+
+	// the email identifies the account once its row is gone
+	entry := models.AuditEntry{Action: auditUserDelete, TargetType: "user", TargetID: user.UserID,
+		Details: map[string]interface{}{"email": user.Email}}
+	var deleted *deletedAccount
+	err = s.audited(c, &entry, func(tx *database.Store) error {
+		deleted, err = deleteUserData(c.UserContext(), tx, user.UserID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.cleanUpAccount(c.UserContext(), deleted)
+
+	return c.JSON(fiber.Map{"message": "user deleted"})
+}
+
+// modeRequest is the body of POST /admin/modes and PUT /admin/modes/:mode_id.
+// A mode is priced with Impact CO₂ when mapped to one of its transports, with
+// its emission factor otherwise, so one of them is required.
+type modeRequest struct {
+	ModeName              string   `json:"mode_name" validate:"required,max=100"`
+	Description           *string  `json:"description" validate:"omitempty,max=255"`
+	Category              string   `json:"category" validate:"required,oneof=active public private air"`
+	Icon                  *string  `json:"icon" validate:"omitempty,max=100"`
+	EmissionFactorKgPerKm *float64 `json:"emission_factor_kg_per_km" validate:"required_without=ImpactCO2ID,omitempty,gte=0,lte=10"`
+	ImpactCO2ID           *int     `json:"impact_co2_id" validate:"omitempty,gt=0"`
+	// Enabled is true for new modes and unchanged on updates when not given
+	Enabled      *bool             `json:"enabled"`
+	Names        map[string]string `json:"names" validate:"omitempty,dive,keys,language,endkeys,required,max=100"`
+	Descriptions map[string]string `json:"descriptions" validate:"omitempty,dive,keys,language,endkeys,required,max=255"`
+}
+
+// mode returns the mode described by the request
+func (req modeRequest) mode(modeID int, enabled bool) models.TransportationMode {
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	return models.TransportationMode{
+		ModeID:                modeID,
+		ModeName:              req.ModeName,
+		Description:           req.Description,
+		Category:              req.Category,
+		Icon:                  req.Icon,
+		EmissionFactorKgPerKm: req.EmissionFactorKgPerKm,
+		ImpactCO2ID:           req.ImpactCO2ID,
+		Enabled:               enabled,
+		Names:                 req.Names,
+		Descriptions:          req.Descriptions,
+	}
+}
+
+func (s *Server) adminModesHandler(c *fiber.Ctx) error {
+	// unlike GET /transportation, with the disabled modes
+	modes, err := s.store.Modes.GetAll(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"modes": modes})
+}
+
+func (s *Server) adminCreateModeHandler(c *fiber.Ctx) error {
+	var req modeRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	mode := req.mode(0, true)
+	entry := models.AuditEntry{Action: auditModeCreate, TargetType: "mode",
+		Details: map[string]interface{}{"mode_name": mode.ModeName}}
+	err := s.audited(c, &entry, func(tx *database.Store) error {
+		if err := tx.Modes.Create(c.UserContext(), &mode); err != nil {
+			return err
+		}
+		entry.TargetID = mode.ModeID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"mode": mode})
+}
+
+func (s *Server) adminUpdateModeHandler(c *fiber.Ctx) error {
+	var req modeRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	modeID, err := idParam(c, "mode_id")
+	if err != nil {
+		return err
+	}
+
+	var mode models.TransportationMode
+	entry := models.AuditEntry{Action: auditModeUpdate, TargetType: "mode", TargetID: modeID,
+		Details: map[string]interface{}{"mode_name": req.ModeName}}
+	err = s.audited(c, &entry, func(tx *database.Store) error {
+		current, err := tx.Modes.GetByID(c.UserContext(), modeID)
+		if err != nil {
+			return err
+		}
+		mode = req.mode(modeID, current.Enabled)
+		if mode.Enabled != current.Enabled {
+			entry.Details["enabled"] = mode.Enabled
+		}
+		return tx.Modes.Update(c.UserContext(), mode)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"mode": mode})
+}
+
+func (s *Server) adminDeleteModeHandler(c *fiber.Ctx) error {
+	modeID, err := idParam(c, "mode_id")
+	if err != nil {
+		return err
+	}
+
+	entry := models.AuditEntry{Action: auditModeDelete, TargetType: "mode", TargetID: modeID}
+	err = s.audited(c, &entry, func(tx *database.Store) error {
+		return tx.Modes.Delete(c.UserContext(), modeID)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "mode deleted"})
+}
+
+// challengeRequest is the body of POST /admin/challenges and PUT /admin/challenges/:challenge_id
+type challengeRequest struct {
+	Name        string  `json:"name" validate:"required,max=100"`
+	Description *string `json:"description" validate:"omitempty,max=1000"`
+	StartDate   string  `json:"start_date" validate:"required,date"`
+	EndDate     string  `json:"end_date" validate:"required,date"`
+}
+
+// challenge returns the challenge described by the request
+func (req challengeRequest) challenge() (models.Challenge, error) {
+	start, _ := utils.ConvertStringToTime(req.StartDate)
+	end, _ := utils.ConvertStringToTime(req.EndDate)
+	if end.Before(start) {
+		return models.Challenge{}, errValidation([]FieldError{fieldError("end_date", "after", "must not be before %s", "start_date")})
+	}
+	return models.Challenge{Name: req.Name, Description: req.Description, StartDate: start, EndDate: end}, nil
+}
+
+func (s *Server) adminChallengesHandler(c *fiber.Ctx) error {
+	challenges, err := s.store.Challenges.GetAll(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"challenges": challenges})
+}
+
+func (s *Server) adminCreateChallengeHandler(c *fiber.Ctx) error {
+	var req challengeRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	challenge, err := req.challenge()
+	if err != nil {
+		return err
+	}
+
+	entry := models.AuditEntry{Action: auditChallengeCreate, TargetType: "challenge",
+		Details: map[string]interface{}{"name": challenge.Name}}
+	err = s.audited(c, &entry, func(tx *database.Store) error {
+		if err := tx.Challenges.Create(c.UserContext(), &challenge); err != nil {
+			return err
+		}
+		entry.TargetID = challenge.ChallengeID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"challenge": challenge})
+}
+
+func (s *Server) adminUpdateChallengeHandler(c *fiber.Ctx) error {
+	var req challengeRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	challengeID, err := idParam(c, "challenge_id")
+	if err != nil {
+		return err
+	}
+	challenge, err := req.challenge()
+	if err != nil {
+		return err
+	}
+	challenge.ChallengeID = challengeID
+
+	entry := models.AuditEntry{Action: auditChallengeUpdate, TargetType: "challenge", TargetID: challengeID,
+		Details: map[string]interface{}{"name": challenge.Name}}
+	err = s.audited(c, &entry, func(tx *database.Store) error {
+		return tx.Challenges.Update(c.UserContext(), challenge)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"challenge": challenge})
+}
+
+func (s *Server) adminDeleteChallengeHandler(c *fiber.Ctx) error {
+	challengeID, err := idParam(c, "challenge_id")
+	if err != nil {
+		return err
+	}
+
+	entry := models.AuditEntry{Action: auditChallengeDelete, TargetType: "challenge", TargetID: challengeID}
+	err = s.audited(c, &entry, func(tx *database.Store) error {
+		return tx.Challenges.Delete(c.UserContext(), challengeID)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "challenge deleted"})
+}
+
+// auditQuery is the query of GET /admin/audit
+type auditQuery struct {
+	Limit  int `query:"limit" validate:"gte=0,lte=200"`
+	Offset int `query:"offset" validate:"gte=0"`
+}
+
+func (s *Server) adminAuditHandler(c *fiber.Ctx) error {
+	var req auditQuery
+	if err := parseQuery(c, &req); err != nil {
+		return err
+	}
+	if req.Limit == 0 {
+		req.Limit = adminPageSize
+	}
+
+	entries, err := s.store.Audit.List(c.UserContext(), req.Limit, req.Offset)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"entries": entries})
+}
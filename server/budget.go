@@ -0,0 +1,145 @@
+package server
+
+import (
+	"API/database"
+	"API/units"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+)
+
+func (s *Server) budgetHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
+	}
+
+	// Get consumed, remaining and projected budget
+	status, err := s.store.GetBudgetStatus(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"budget": convertBudget(status, system), "units": units.Of(system)})
+}
+
+// budgetRequest is the body of PUT /user/budget, without a limit it is derived from the yearly goal. The
+// limit and the goal are in kg, or in the unit system of the request without the _kg suffix.
+type budgetRequest struct {
+	Period       string  `json:"period" validate:"omitempty,oneof=monthly yearly"`
+	LimitKg      float64 `json:"limit_kg" validate:"gte=0"`
+	Limit        float64 `json:"limit" validate:"gte=0,excluded_with=LimitKg"`
+	YearlyGoalKg float64 `json:"yearly_goal_kg" validate:"gte=0"`
+	YearlyGoal   float64 `json:"yearly_goal" validate:"gte=0,excluded_with=YearlyGoalKg"`
+}
+
+func (s *Server) setBudgetHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req budgetRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
+	}
+
+	if req.Period == "" {
+		req.Period = database.BudgetPeriodYearly
+	}
+	if req.Limit != 0 {
+		req.LimitKg = units.ToKg(req.Limit, system)
+	}
+	if req.YearlyGoal != 0 {
+		req.YearlyGoalKg = units.ToKg(req.YearlyGoal, system)
+	}
+
+	// without an explicit limit derive it from a yearly goal, the per-capita one by default
+	if req.LimitKg == 0 {
+		if req.YearlyGoalKg == 0 {
+			req.YearlyGoalKg = database.PerCapitaYearlyGoalKg
+		}
+		req.LimitKg = database.BudgetLimitFromYearlyGoal(req.Period, req.YearlyGoalKg)
+	}
+
+	if err := s.store.SetUserBudget(c.UserContext(), userID, req.Period, req.LimitKg); err != nil {
+		return err
+	}
+
+	// trips already recorded this period may cross thresholds right away
+	if err := s.store.CheckBudgetAlerts(c.UserContext(), userID, language(c)); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "budget updated", "period": req.Period, "limit_kg": req.LimitKg,
+		"limit": units.Mass(req.LimitKg, system), "units": units.Of(system)})
+}
+
+func (s *Server) deleteBudgetHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	if err := s.store.Budgets.DeleteByUser(c.UserContext(), userID); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "budget deleted"})
+}
+
+func (s *Server) notificationsHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	notifications, err := s.store.Notifications.GetByUser(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"notifications": notifications})
+}
+
+func (s *Server) readNotificationHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	notificationID, err := strconv.Atoi(c.Params("notification_id"))
+	if err != nil {
+		return invalidParameter("invalid %s", "notification_id")
+	}
+
+	if err := s.store.Notifications.MarkRead(c.UserContext(), userID, notificationID); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "notification read"})
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"API/database"
+	"API/i18n"
+	"API/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Error is an error detected by a handler, with the status and the stable
+// code sent to the client
+type Error struct {
+	Status int
+	Code   string
+	Detail string
+	// Fields lists the invalid fields of a validation error
+	Fields []FieldError
+	// format and args give the detail, translated for the client
+	format string
+	args   []interface{}
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func newError(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail, format: detail}
+}
+
+// newErrorf is newError with a detail formatted from args
+func newErrorf(status int, code, format string, args ...interface{}) *Error {
+	return &Error{Status: status, Code: code, Detail: fmt.Sprintf(format, args...), format: format, args: args}
+}
+
+// invalidParameter reports an invalid path or query parameter
+func invalidParameter(format string, args ...interface{}) *Error {
+	return newErrorf(fiber.StatusBadRequest, "invalid_parameter", format, args...)
+}
+
+var (
+	errInvalidBody     = newError(fiber.StatusBadRequest, "invalid_body", "invalid request body")
+	errInvalidQuery    = newError(fiber.StatusBadRequest, "invalid_query", "invalid query parameters")
+	errUserIDRequired  = newError(fiber.StatusBadRequest, "user_id_required", "user_id is required")
+	errUnauthorized    = newError(fiber.StatusUnauthorized, "unauthorized", "unauthorized")
+	errTokenGeneration = newError(fiber.StatusInternalServerError, "token_generation_failed", "failed to generate token")
+)
+
+// statusClientClosedRequest is the nginx status of the requests canceled by
+// the client, which has no standard one
+const statusClientClosedRequest = 499
+
+// errorMappings gives the status and code of the errors of the other packages,
+// the first match wins
+var errorMappings = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{database.ErrUserNotFound, fiber.StatusNotFound, "user_not_found"},
+	{database.ErrTripNotFound, fiber.StatusNotFound, "trip_not_found"},
+	{database.ErrModeNotFound, fiber.StatusNotFound, "mode_not_found"},
+	{database.ErrBudgetNotFound, fiber.StatusNotFound, "budget_not_found"},
+	{database.ErrNotificationNotFound, fiber.StatusNotFound, "notification_not_found"},
+	{database.ErrChallengeNotFound, fiber.StatusNotFound, "challenge_not_found"},
+	{database.ErrEmailChangeNotFound, fiber.StatusNotFound, "email_change_not_found"},
+	{database.ErrEmailExists, fiber.StatusConflict, "email_exists"},
+	{database.ErrUsernameExists, fiber.StatusConflict, "username_exists"},
+	{database.ErrModeInUse, fiber.StatusConflict, "mode_in_use"},
+	{database.ErrInvalidCredentials, fiber.StatusUnauthorized, "invalid_credentials"},
+	{database.ErrWrongPassword, fiber.StatusForbidden, "wrong_password"},
+	{database.ErrInvalidToken, fiber.StatusUnprocessableEntity, "invalid_token"},
+	{database.ErrDeletionNotFound, fiber.StatusNotFound, "deletion_not_found"},
+	{database.ErrDeletionScheduled, fiber.StatusConflict, "deletion_scheduled"},
+	{database.ErrAccountDisabled, fiber.StatusForbidden, "account_disabled"},
+	{database.ErrForbidden, fiber.StatusForbidden, "forbidden"},
+	{database.ErrInvalidInput, fiber.StatusUnprocessableEntity, "invalid_input"},
+	{context.DeadlineExceeded, fiber.StatusGatewayTimeout, "timeout"},
+	// the client went away, nobody reads the response
+	{context.Canceled, statusClientClosedRequest, "client_closed_request"},
+	{utils.ErrAddressNotFound, fiber.StatusUnprocessableEntity, "address_not_found"},
+	{utils.ErrProviderUnavailable, fiber.StatusBadGateway, "provider_unavailable"},
+}
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// problemFor returns the problem describing err, in the language lang
+func problemFor(err error, lang string) Problem {
+	problem := Problem{
+		Type:   "about:blank",
+		Status: fiber.StatusInternalServerError,
+		Code:   "internal_error",
+	}
+
+	var apiErr *Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &apiErr):
+		problem.Status, problem.Code = apiErr.Status, apiErr.Code
+		problem.Detail = i18n.T(lang, apiErr.format, apiErr.args...)
+		problem.Errors = localizeFields(apiErr.Fields, lang)
+	case errors.As(err, &fiberErr):
+		// errors of Fiber itself, e.g. an unknown route
+		problem.Status, problem.Code, problem.Detail = fiberErr.Code, codeFromStatus(fiberErr.Code), fiberErr.Message
+	default:
+		for _, mapping := range errorMappings {
+			if errors.Is(err, mapping.err) {
+				problem.Status, problem.Code, problem.Detail = mapping.status, mapping.code, i18n.T(lang, matchedError(err, mapping.err).Error())
+				break
+			}
+		}
+	}
+
+	// the details of server side failures stay in the logs
+	if problem.Status >= fiber.StatusInternalServerError && apiErr == nil {
+		problem.Detail = ""
+	}
+	problem.Title = i18n.T(lang, statusText(problem.Status))
+	return problem
+}
+
+// matchedError returns the error of the chain of err that matches target,
+// without the messages wrapping it, e.g. "user not found" rather than "failed
+// to get user: user not found". The invalid input errors keep their own
+// message.
+func matchedError(err, target error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil || !errors.Is(next, target) {
+			return err
+		}
+		err = next
+	}
+}
+
+// statusText is http.StatusText, with statusClientClosedRequest
+func statusText(status int) string {
+	if status == statusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
+
+// codeFromStatus turns "Not Found" into "not_found"
+func codeFromStatus(status int) string {
+	return strings.ReplaceAll(strings.ToLower(statusText(status)), " ", "_")
+}
+
+// ErrorHandler writes the errors returned by the handlers as problem+json
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	problem := problemFor(err, language(c))
+	problem.Instance = c.OriginalURL()
+	problem.RequestID = requestID(c)
+	if problem.Status >= fiber.StatusInternalServerError {
+		slog.ErrorContext(c.UserContext(), "Request failed", "method", c.Method(), "path", c.Path(), "error", err)
+	}
+
+	return c.Status(problem.Status).JSON(problem, "application/problem+json")
+}
+
+// requestID returns the ID given to the request by the requestid middleware
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestid").(string)
+	return id
+}
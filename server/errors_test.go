@@ -0,0 +1,35 @@
+package server
+
+import (
+	"API/database"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestProblemFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		lang   string
+		err    error
+		status int
+		code   string
+		title  string
+		detail string
+	}{
+		{"wrapped sentinel", "en", fmt.Errorf("failed to get user: %w", database.ErrUserNotFound), 404, "user_not_found", "Not Found", "user not found"},
+		{"translated", "fr", fmt.Errorf("failed to get user: %w", database.ErrUserNotFound), 404, "user_not_found", "Introuvable", "utilisateur introuvable"},
+		{"canceled", "en", fmt.Errorf("failed to get trips: %w", context.Canceled), 499, "client_closed_request", "Client Closed Request", "context canceled"},
+		{"timeout", "en", fmt.Errorf("failed to get trips: %w", context.DeadlineExceeded), 504, "timeout", "Gateway Timeout", ""},
+		{"unknown", "en", fmt.Errorf("boom"), 500, "internal_error", "Internal Server Error", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := problemFor(tt.err, tt.lang)
+			if problem.Status != tt.status || problem.Code != tt.code || problem.Title != tt.title || problem.Detail != tt.detail {
+				t.Errorf("got %d %q %q %q, want %d %q %q %q", problem.Status, problem.Code, problem.Title, problem.Detail,
+					tt.status, tt.code, tt.title, tt.detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"log/slog"
+	"time"
+)
+
+// readinessTimeout bounds the checks of /readyz, shorter than the timeout of
+// the App Engine health checks
+const readinessTimeout = 3 * time.Second
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// healthResponse is the body of /healthz and /readyz. Checks holds "ok" or
+// "fail" for each dependency checked, the errors are logged only.
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthQuery is the query of /readyz
+type healthQuery struct {
+	// Providers also checks the geocoding and Impact CO₂ APIs
+	Providers bool `query:"providers"`
+}
+
+// registerHealth serves the liveness and readiness checks
+func (s *Server) registerHealth() {
+	s.app.Get("/healthz", s.healthzHandler)
+	s.app.Get("/readyz", s.readyzHandler)
+}
+
+// healthzHandler answers as long as the process serves requests
+func (s *Server) healthzHandler(c *fiber.Ctx) error {
+	return c.JSON(healthResponse{Status: statusOK})
+}
+
+// readyzHandler checks that the database, and the providers when asked, are
+// reachable, so that no traffic is routed to an instance that cannot serve it
+func (s *Server) readyzHandler(c *fiber.Ctx) error {
+	var query healthQuery
+	if err := parseQuery(c, &query); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), readinessTimeout)
+	defer cancel()
+
+	response := healthResponse{Status: statusOK, Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			slog.WarnContext(ctx, "Readiness check failed", "check", name, "error", err)
+			response.Status = statusFail
+			response.Checks[name] = statusFail
+			return
+		}
+		response.Checks[name] = statusOK
+	}
+
+	check("database", s.store.Ping(ctx))
+	if query.Providers && s.store.Providers != nil {
+		for name, err := range s.store.Providers.Check(ctx) {
+			check(name, err)
+		}
+	}
+
+	if response.Status != statusOK {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(response)
+	}
+	return c.JSON(response)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"API/metrics"
+	"crypto/subtle"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unmatchedRoute labels the requests that matched no route, so that unknown
+// paths do not each get their own series
+const unmatchedRoute = "unmatched"
+
+// MetricsMiddleware counts the requests and their duration by route pattern
+// and status. It runs outside of LoggingMiddleware to see the final status.
+func (s *Server) MetricsMiddleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	route := c.Route().Path
+	if !s.routes[c.Route().Method+" "+route] {
+		route = unmatchedRoute
+	}
+	status := strconv.Itoa(c.Response().StatusCode())
+	metrics.HTTPRequests.WithLabelValues(c.Method(), route, status).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(c.Method(), route).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// indexRoutes records the route patterns MetricsMiddleware labels the requests
+// with: the routes, and the prefixes of the middlewares of the groups, which
+// label the requests they reject. The global middlewares, registered at /,
+// are left out as they are what c.Route() returns when no route matched.
+func (s *Server) indexRoutes() {
+	s.routes = make(map[string]bool)
+	for _, route := range s.app.GetRoutes(true) {
+		s.routes[route.Method+" "+route.Path] = true
+	}
+	for _, route := range s.app.GetRoutes(false) {
+		if route.Path != "/" {
+			s.routes[route.Method+" "+route.Path] = true
+		}
+	}
+}
+
+// registerMetrics serves /metrics on the API port to the requests sending the
+// metrics token, when one is configured
+func (s *Server) registerMetrics() {
+	if s.cfg.Metrics.Token == "" {
+		return
+	}
+	handler := adaptor.HTTPHandler(metrics.Handler())
+	token := []byte(s.cfg.Metrics.Token)
+	s.app.Get("/metrics", func(c *fiber.Ctx) error {
+		bearer, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), token) != 1 {
+			return errUnauthorized
+		}
+		return handler(c)
+	})
+}
+
+// newMetricsServer returns the server of /metrics on the admin port, meant to
+// be reachable by the scraper only
+func newMetricsServer(port string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	return &http.Server{Addr: ":" + port, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"API/i18n"
+	"API/telemetry"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// TimeoutMiddleware gives each request a context with a deadline, bounding its
+// database queries and outbound calls. Handlers pass c.UserContext() down so
+// that the work stops once it expires.
+func TimeoutMiddleware(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+// TracingMiddleware starts a server span for each request, continuing the
+// trace of the caller when it sends a traceparent header
+func TracingMiddleware(c *fiber.Ctx) error {
+	carrier := propagation.MapCarrier{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		carrier.Set(strings.ToLower(string(key)), string(value))
+	})
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier)
+	ctx, span := telemetry.Tracer().Start(ctx, c.Method()+" "+c.Path(), trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+
+	// the route is known once the request has been routed
+	route := c.Route().Path
+	span.SetName(c.Method() + " " + route)
+	status := c.Response().StatusCode()
+	span.SetAttributes(
+		attribute.String("http.request.method", c.Method()),
+		attribute.String("http.route", route),
+		attribute.Int("http.response.status_code", status),
+		attribute.String("request.id", requestID(c)),
+	)
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, utils.StatusMessage(status))
+	}
+	return err
+}
+
+// quietRoutes are polled by the platform, their successes are logged at the
+// debug level only
+var quietRoutes = map[string]bool{"/healthz": true, "/readyz": true}
+
+// LoggingMiddleware logs every request with its status and latency. Errors
+// are written by the ErrorHandler here so that the logged status is the final
+// one. The request ID is added to the context for the logs and outbound calls.
+func LoggingMiddleware(c *fiber.Ctx) error {
+	start := time.Now()
+	c.SetUserContext(telemetry.WithRequestID(c.UserContext(), requestID(c)))
+
+	if err := c.Next(); err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	status := c.Response().StatusCode()
+	attrs := []any{
+		"method", c.Method(),
+		"path", c.Path(),
+		"route", c.Route().Path,
+		"status", status,
+		"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		"bytes", len(c.Response().Body()),
+		"ip", clientIP(c),
+	}
+	if userID, ok := c.Locals("user").(float64); ok {
+		attrs = append(attrs, "user_id", int(userID))
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case quietRoutes[c.Route().Path] && status < fiber.StatusBadRequest:
+		level = slog.LevelDebug
+	case status >= fiber.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= fiber.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+	slog.Log(c.UserContext(), level, "Request", attrs...)
+	return nil
+}
+
+// ClientIPMiddleware reads the address of the client from the header set by
+// the proxies, when there is one. Only the last hops entries are appended by
+// the proxies, the client may send any entries before them, so the address
+// is the entry hops from the end. The peer address is used when the header
+// has no valid address there.
+func ClientIPMiddleware(header string, hops int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ip := c.IP()
+		if header != "" {
+			entries := strings.Split(c.Get(header), ",")
+			if i := len(entries) - hops; i >= 0 {
+				if addr, err := netip.ParseAddr(strings.TrimSpace(entries[i])); err == nil {
+					ip = addr.Unmap().String()
+				}
+			}
+		}
+		c.Locals("ip", ip)
+		return c.Next()
+	}
+}
+
+// LanguageMiddleware negotiates the language of the messages from the
+// Accept-Language header. AuthMiddleware replaces it with the language chosen
+// by the user, if any.
+func LanguageMiddleware(c *fiber.Ctx) error {
+	c.Vary(fiber.HeaderAcceptLanguage)
+	setLanguage(c, i18n.Negotiate(c.Get(fiber.HeaderAcceptLanguage)))
+	return c.Next()
+}
+
+func setLanguage(c *fiber.Ctx, lang string) {
+	c.Locals("lang", lang)
+	c.Set(fiber.HeaderContentLanguage, lang)
+}
+
+// language returns the language of the messages sent to the client
+func language(c *fiber.Ctx) string {
+	if lang, ok := c.Locals("lang").(string); ok {
+		return lang
+	}
+	return i18n.Negotiate(c.Get(fiber.HeaderAcceptLanguage))
+}
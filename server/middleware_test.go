@@ -0,0 +1,48 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		hops   int
+		xff    string
+		want   string
+	}{
+		{"no proxy header", "", 1, "203.0.113.9", "0.0.0.0"},
+		{"one proxy", "X-Forwarded-For", 1, "203.0.113.9", "203.0.113.9"},
+		{"spoofed entries are skipped", "X-Forwarded-For", 1, "198.51.100.1, 203.0.113.9", "203.0.113.9"},
+		{"two proxies", "X-Forwarded-For", 2, "198.51.100.1, 203.0.113.9, 35.191.0.1", "203.0.113.9"},
+		{"fewer entries than hops", "X-Forwarded-For", 2, "203.0.113.9", "0.0.0.0"},
+		{"invalid entry", "X-Forwarded-For", 1, "unknown", "0.0.0.0"},
+		{"missing header", "X-Forwarded-For", 1, "", "0.0.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(ClientIPMiddleware(tt.header, tt.hops))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(clientIP(c))
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if got := string(body); got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,477 @@
+package server
+
+import (
+	"API/models"
+	"API/openapi"
+	"API/reports"
+	"API/units"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// routeDoc documents a route of registerRoutes in the OpenAPI document
+type routeDoc struct {
+	method  string
+	path    string
+	tag     string
+	summary string
+	auth    bool
+	// params are the path and query parameters, query is a struct whose
+	// query tags are added to them
+	params []openapi.Parameter
+	query  interface{}
+	body   interface{}
+	// upload is the file field of a multipart body, instead of a JSON one
+	upload string
+	// status is the success status, 200 when 0
+	status   int
+	response interface{}
+	// contentTypes replaces the JSON success response, e.g. for downloads
+	contentTypes []string
+	extra        map[int]interface{}
+}
+
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+type reportResponse struct {
+	Report reports.Job `json:"report"`
+}
+
+type pointsResponse struct {
+	Points []Point      `json:"points"`
+	Units  units.Labels `json:"units"`
+}
+
+type userResponse struct {
+	User models.User `json:"user"`
+}
+
+type preferencesResponse struct {
+	Message     string             `json:"message,omitempty"`
+	Preferences models.Preferences `json:"preferences"`
+}
+
+type modeResponse struct {
+	Mode models.TransportationMode `json:"mode"`
+}
+
+type challengeResponse struct {
+	Challenge models.Challenge `json:"challenge"`
+}
+
+func pathParam(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "path", Required: true, Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func queryParam(name, typ, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: typ}}
+}
+
+// unitsParam selects the unit system of the distances and masses
+var unitsParam = queryParam("units", "string", "metric or imperial, the preference of the user by default")
+
+// routeDocs lists every route of the version 1 of the API, relative to /v1.
+// TestRoutesDocumented fails if one is missing.
+var routeDocs = []routeDoc{
+	{method: "POST", path: "/register", tag: "auth", summary: "Create an account",
+		body: registerRequest{}, status: fiber.StatusCreated,
+		response: struct {
+			Message string `json:"message"`
+			UserID  int    `json:"user_id"`
+		}{}},
+	{method: "POST", path: "/auth/login", tag: "auth", summary: "Get a JWT",
+		body: loginRequest{},
+		response: struct {
+			Token string `json:"token"`
+		}{}},
+	{method: "POST", path: "/auth/login/cookie", tag: "auth", summary: "Set the JWT as a cookie and redirect to /",
+		body: loginRequest{}, status: fiber.StatusFound},
+
+	{method: "GET", path: "/user/info", tag: "user", summary: "Get the user", auth: true,
+		response: struct {
+			User models.User `json:"user"`
+		}{}},
+	{method: "PATCH", path: "/user", tag: "user", summary: "Change the username or the email, a new email is set once verified", auth: true,
+		body: userUpdateRequest{},
+		response: struct {
+			Message      string      `json:"message"`
+			User         models.User `json:"user"`
+			PendingEmail string      `json:"pending_email,omitempty"`
+		}{}},
+	{method: "POST", path: "/user/email/verify", tag: "user", summary: "Confirm the new email with the token sent to it", auth: true,
+		body: verifyEmailRequest{},
+		response: struct {
+			Message string `json:"message"`
+			Email   string `json:"email"`
+		}{}},
+	{method: "PUT", path: "/user/password", tag: "user", summary: "Change the password, given the current one", auth: true,
+		body: passwordRequest{}, response: messageResponse{}},
+	{method: "GET", path: "/user/preferences", tag: "user", summary: "Get the preferences", auth: true,
+		response: preferencesResponse{}},
+	{method: "PUT", path: "/user/preferences", tag: "user", summary: "Replace the preferences, the omitted ones get their default", auth: true,
+		body: preferencesRequest{}, response: preferencesResponse{}},
+	{method: "GET", path: "/user/avatar", tag: "user", summary: "Download the avatar", auth: true,
+		contentTypes: []string{"image/png", "image/jpeg", "image/gif", "image/webp"}},
+	{method: "PUT", path: "/user/avatar", tag: "user", summary: "Upload a PNG, JPEG, GIF or WebP avatar of at most 2 MB", auth: true,
+		upload: "avatar", response: messageResponse{}},
+	{method: "DELETE", path: "/user/avatar", tag: "user", summary: "Remove the avatar", auth: true,
+		response: messageResponse{}},
+	{method: "GET", path: "/user/export", tag: "user", summary: "Download a ZIP of all the personal data, as JSON and CSV", auth: true,
+		contentTypes: []string{"application/zip"}},
+	{method: "GET", path: "/user/deletion", tag: "user", summary: "Get the pending deletion of the account", auth: true,
+		response: struct {
+			Deletion models.AccountDeletion `json:"deletion"`
+		}{}},
+	{method: "POST", path: "/user/deletion", tag: "user", summary: "Request the deletion of the account, a token is sent to the email", auth: true,
+		body: deletionRequest{}, status: fiber.StatusAccepted, response: messageResponse{}},
+	{method: "POST", path: "/user/deletion/confirm", tag: "user", summary: "Confirm the deletion with the token, it happens after the grace period", auth: true,
+		body: confirmDeletionRequest{},
+		response: struct {
+			Message  string                 `json:"message"`
+			Deletion models.AccountDeletion `json:"deletion"`
+		}{}},
+	{method: "DELETE", path: "/user/deletion", tag: "user", summary: "Cancel the deletion of the account", auth: true,
+		response: messageResponse{}},
+	{method: "PUT", path: "/user/baseline", tag: "user", summary: "Set the mode the savings are computed against", auth: true,
+		body: baselineRequest{},
+		response: struct {
+			Message        string `json:"message"`
+			BaselineModeID int    `json:"baseline_mode_id"`
+		}{}},
+	{method: "PUT", path: "/user/language", tag: "user", summary: "Set the language of the messages, empty to follow Accept-Language", auth: true,
+		body: languageRequest{},
+		response: struct {
+			Message  string `json:"message"`
+			Language string `json:"language"`
+		}{}},
+	{method: "GET", path: "/user/budget", tag: "budget", summary: "Get the consumption of the carbon budget", auth: true,
+		params: []openapi.Parameter{unitsParam},
+		response: struct {
+			Budget budgetResponse `json:"budget"`
+			Units  units.Labels   `json:"units"`
+		}{}},
+	{method: "PUT", path: "/user/budget", tag: "budget", summary: "Set the carbon budget", auth: true,
+		params: []openapi.Parameter{unitsParam},
+		body:   budgetRequest{},
+		response: struct {
+			Message string       `json:"message"`
+			Period  string       `json:"period"`
+			LimitKg float64      `json:"limit_kg"`
+			Limit   float64      `json:"limit"`
+			Units   units.Labels `json:"units"`
+		}{}},
+	{method: "DELETE", path: "/user/budget", tag: "budget", summary: "Remove the carbon budget", auth: true,
+		response: messageResponse{}},
+	{method: "GET", path: "/user/notifications", tag: "budget", summary: "List the notifications", auth: true,
+		response: struct {
+			Notifications []models.Notification `json:"notifications"`
+		}{}},
+	{method: "POST", path: "/user/notifications/:notification_id/read", tag: "budget", summary: "Mark a notification as read", auth: true,
+		params:   []openapi.Parameter{pathParam("notification_id", "")},
+		response: messageResponse{}},
+	{method: "GET", path: "/user/reports/:period", tag: "reports", summary: "Download a report, 202 while it is generated", auth: true,
+		params: []openapi.Parameter{
+			pathParam("period", "YYYY for a yearly report, YYYY-MM for a monthly one"),
+			queryParam("format", "string", "pdf (default) or html"),
+		},
+		contentTypes: []string{"application/pdf", "text/html"},
+		extra:        map[int]interface{}{fiber.StatusAccepted: reportResponse{}}},
+	{method: "POST", path: "/user/reports/:period", tag: "reports", summary: "(Re)generate a report in the background", auth: true,
+		params: []openapi.Parameter{pathParam("period", "YYYY for a yearly report, YYYY-MM for a monthly one")},
+		status: fiber.StatusAccepted, response: reportResponse{}},
+
+	{method: "GET", path: "/trips", tag: "trips", summary: "List the trips", auth: true,
+		params: []openapi.Parameter{unitsParam},
+		response: struct {
+			Trips []tripResponse `json:"trips"`
+			Units units.Labels   `json:"units"`
+		}{}},
+	{method: "POST", path: "/trips", tag: "trips", summary: "Record a trip", auth: true,
+		params: []openapi.Parameter{unitsParam},
+		body:   createTripRequest{}, response: messageResponse{}},
+	{method: "GET", path: "/trips/impactgraphday", tag: "trips", summary: "Cumulative impact over the year, one point per day", auth: true,
+		params: []openapi.Parameter{unitsParam}, response: pointsResponse{}},
+	{method: "GET", path: "/trips/impactgraphmonth", tag: "trips", summary: "Cumulative impact over the year, one point per month", auth: true,
+		params: []openapi.Parameter{unitsParam}, response: pointsResponse{}},
+	{method: "GET", path: "/trips/aggregation", tag: "trips", summary: "Trips aggregated by mode", auth: true,
+		params: []openapi.Parameter{unitsParam},
+		response: struct {
+			Trips []models.TripsByMode `json:"trips"`
+			Units units.Labels         `json:"units"`
+		}{}},
+	{method: "GET", path: "/trips/impact", tag: "trips", summary: "Total carbon impact", auth: true,
+		params: []openapi.Parameter{unitsParam},
+		response: struct {
+			TotalImpact float64      `json:"total_impact"`
+			Units       units.Labels `json:"units"`
+		}{}},
+	{method: "GET", path: "/trips/compare", tag: "trips", summary: "Compare the impact of every mode for a trip", auth: true,
+		query: compareQuery{}, params: []openapi.Parameter{unitsParam},
+		response: struct {
+			DistanceKm float64                  `json:"distance_km"`
+			Distance   float64                  `json:"distance"`
+			Modes      []modeComparisonResponse `json:"modes"`
+			Units      units.Labels             `json:"units"`
+		}{}},
+	{method: "GET", path: "/trips/savings", tag: "trips", summary: "Carbon avoided compared to the baseline mode", auth: true,
+		params: []openapi.Parameter{unitsParam},
+		response: struct {
+			Savings models.Savings `json:"savings"`
+			Units   units.Labels   `json:"units"`
+		}{}},
+	{method: "GET", path: "/trips/savings/monthly", tag: "trips", summary: "Carbon avoided per month", auth: true,
+		params: []openapi.Parameter{unitsParam},
+		response: struct {
+			Savings []models.Savings `json:"savings"`
+			Units   units.Labels     `json:"units"`
+		}{}},
+	{method: "GET", path: "/trips/forecast", tag: "trips", summary: "Forecast of the monthly emissions", auth: true,
+		params: []openapi.Parameter{
+			queryParam("months", "integer", "number of months to forecast, 1 to 12, 3 by default"),
+			queryParam("method", "string", "auto (default), seasonal_naive or exponential_smoothing"),
+			unitsParam,
+		},
+		response: struct {
+			Forecast forecastResponse `json:"forecast"`
+			Units    units.Labels     `json:"units"`
+		}{}},
+
+	{method: "GET", path: "/transportation", tag: "transportation", summary: "List the enabled transportation modes",
+		response: struct {
+			Modes []models.TransportationMode `json:"modes"`
+		}{}},
+	{method: "GET", path: "/transportation/:mode_id", tag: "transportation", summary: "Get a transportation mode",
+		params: []openapi.Parameter{pathParam("mode_id", "")},
+		response: struct {
+			Mode models.TransportationMode `json:"mode"`
+		}{}},
+
+	{method: "GET", path: "/admin/users", tag: "admin", summary: "Search the users, moderator", auth: true,
+		query: adminUsersQuery{},
+		response: struct {
+			Users []models.User `json:"users"`
+			Total int           `json:"total"`
+		}{}},
+	{method: "GET", path: "/admin/users/:user_id", tag: "admin", summary: "Get a user, moderator", auth: true,
+		params:   []openapi.Parameter{pathParam("user_id", "")},
+		response: userResponse{}},
+	{method: "POST", path: "/admin/users/:user_id/disable", tag: "admin", summary: "Disable an account with a lower role, moderator", auth: true,
+		params:   []openapi.Parameter{pathParam("user_id", "")},
+		response: messageResponse{}},
+	{method: "POST", path: "/admin/users/:user_id/enable", tag: "admin", summary: "Enable an account with a lower role again, moderator", auth: true,
+		params:   []openapi.Parameter{pathParam("user_id", "")},
+		response: messageResponse{}},
+	{method: "PUT", path: "/admin/users/:user_id/role", tag: "admin", summary: "Set the role of a user, admin", auth: true,
+		params: []openapi.Parameter{pathParam("user_id", "")},
+		body:   roleRequest{},
+		response: struct {
+			Message string `json:"message"`
+			Role    string `json:"role"`
+		}{}},
+	{method: "DELETE", path: "/admin/users/:user_id", tag: "admin", summary: "Delete a user and their data, admin", auth: true,
+		params:   []openapi.Parameter{pathParam("user_id", "")},
+		response: messageResponse{}},
+	{method: "GET", path: "/admin/modes", tag: "admin", summary: "List the transportation modes, disabled ones included, admin", auth: true,
+		response: struct {
+			Modes []models.TransportationMode `json:"modes"`
+		}{}},
+	{method: "POST", path: "/admin/modes", tag: "admin", summary: "Add a transportation mode, admin", auth: true,
+		body: modeRequest{}, status: fiber.StatusCreated, response: modeResponse{}},
+	{method: "PUT", path: "/admin/modes/:mode_id", tag: "admin", summary: "Update a transportation mode, admin", auth: true,
+		params: []openapi.Parameter{pathParam("mode_id", "")},
+		body:   modeRequest{}, response: modeResponse{}},
+	{method: "DELETE", path: "/admin/modes/:mode_id", tag: "admin", summary: "Delete a transportation mode without trips, admin", auth: true,
+		params:   []openapi.Parameter{pathParam("mode_id", "")},
+		response: messageResponse{}},
+	{method: "GET", path: "/admin/challenges", tag: "admin", summary: "List the challenges, moderator", auth: true,
+		response: struct {
+			Challenges []models.Challenge `json:"challenges"`
+		}{}},
+	{method: "POST", path: "/admin/challenges", tag: "admin", summary: "Create a challenge, moderator", auth: true,
+		body: challengeRequest{}, status: fiber.StatusCreated, response: challengeResponse{}},
+	{method: "PUT", path: "/admin/challenges/:challenge_id", tag: "admin", summary: "Update a challenge, moderator", auth: true,
+		params: []openapi.Parameter{pathParam("challenge_id", "")},
+		body:   challengeRequest{}, response: challengeResponse{}},
+	{method: "DELETE", path: "/admin/challenges/:challenge_id", tag: "admin", summary: "Delete a challenge, moderator", auth: true,
+		params:   []openapi.Parameter{pathParam("challenge_id", "")},
+		response: messageResponse{}},
+	{method: "GET", path: "/admin/audit", tag: "admin", summary: "List the admin actions, latest first, admin", auth: true,
+		query: auditQuery{},
+		response: struct {
+			Entries []models.AuditEntry `json:"entries"`
+		}{}},
+}
+
+// rootRouteDocs lists the routes served outside of the versions
+var rootRouteDocs = []routeDoc{
+	{method: "GET", path: "/openapi.json", tag: "docs", summary: "This document",
+		contentTypes: []string{fiber.MIMEApplicationJSON}},
+	{method: "GET", path: "/docs", tag: "docs", summary: "Documentation UI",
+		contentTypes: []string{fiber.MIMETextHTML}},
+	{method: "GET", path: "/healthz", tag: "health", summary: "Liveness check",
+		response: healthResponse{}},
+	{method: "GET", path: "/readyz", tag: "health", summary: "Readiness check, 503 when a dependency is unreachable",
+		query: healthQuery{}, response: healthResponse{}, extra: map[int]interface{}{fiber.StatusServiceUnavailable: healthResponse{}}},
+}
+
+var fiberParam = regexp.MustCompile(`:(\w+)`)
+
+// openAPIPath turns a Fiber route path into an OpenAPI one, /a/:id/ -> /a/{id}
+func openAPIPath(path string) string {
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return fiberParam.ReplaceAllString(path, "{$1}")
+}
+
+// metricsRouteDoc documents /metrics when it is served on the API port
+var metricsRouteDoc = routeDoc{method: "GET", path: "/metrics", tag: "admin", summary: "Prometheus metrics, for requests sending the metrics token as a bearer token",
+	contentTypes: []string{"text/plain; version=0.0.4"}}
+
+// buildOpenAPI describes the routes of every version, the root aliases of the
+// first one when legacy is set and the routes outside of the versions
+func buildOpenAPI(versions []apiVersion, legacy bool, root []routeDoc) *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "SmartEcoTransport API",
+		Version:     "1.0.0",
+		Description: "Carbon footprint of the trips of the users. Errors are application/problem+json bodies.",
+	})
+	doc.Components.SecuritySchemes["bearerAuth"] = &openapi.SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"}
+	doc.Components.SecuritySchemes["cookieAuth"] = &openapi.SecurityScheme{Type: "apiKey", In: "cookie", Name: "jwt"}
+
+	for _, version := range versions {
+		addOperations(doc, version.prefix(), version.name+"_", version.docs, version.deprecated())
+	}
+	if legacy {
+		addOperations(doc, "", "legacy_", versions[0].docs, true)
+	}
+	addOperations(doc, "", "", root, false)
+	return doc
+}
+
+// addOperations adds the routes to the document, under prefix
+func addOperations(doc *openapi.Document, prefix, idPrefix string, routes []routeDoc, deprecated bool) {
+	problem := map[string]openapi.MediaType{"application/problem+json": {Schema: doc.SchemaOf(Problem{})}}
+	for _, route := range routes {
+		op := &openapi.Operation{
+			Tags:        []string{route.tag},
+			Summary:     route.summary,
+			OperationID: idPrefix + operationID(route.method, route.path),
+			Deprecated:  deprecated,
+			Parameters:  route.params,
+			Responses:   map[string]*openapi.Response{"default": {Description: "Error", Content: problem}},
+		}
+		if route.query != nil {
+			op.Parameters = append(op.Parameters, doc.Parameters(route.query)...)
+		}
+		if route.auth {
+			op.Security = []map[string][]string{{"bearerAuth": {}}, {"cookieAuth": {}}}
+		}
+		if route.body != nil {
+			op.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content:  map[string]openapi.MediaType{fiber.MIMEApplicationJSON: {Schema: doc.SchemaOf(route.body)}},
+			}
+		}
+		if route.upload != "" {
+			file := &openapi.Schema{Type: "object", Required: []string{route.upload},
+				Properties: map[string]*openapi.Schema{route.upload: {Type: "string", Format: "binary"}}}
+			op.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content:  map[string]openapi.MediaType{fiber.MIMEMultipartForm: {Schema: file}},
+			}
+		}
+
+		status := route.status
+		if status == 0 {
+			status = fiber.StatusOK
+		}
+		success := &openapi.Response{Description: "Success"}
+		if route.response != nil {
+			success.Content = map[string]openapi.MediaType{fiber.MIMEApplicationJSON: {Schema: doc.SchemaOf(route.response)}}
+		}
+		if len(route.contentTypes) > 0 {
+			success.Content = make(map[string]openapi.MediaType)
+			for _, contentType := range route.contentTypes {
+				success.Content[contentType] = openapi.MediaType{}
+			}
+		}
+		op.Responses[fmt.Sprint(status)] = success
+		for extraStatus, response := range route.extra {
+			op.Responses[fmt.Sprint(extraStatus)] = &openapi.Response{
+				Description: "Success",
+				Content:     map[string]openapi.MediaType{fiber.MIMEApplicationJSON: {Schema: doc.SchemaOf(response)}},
+			}
+		}
+
+		doc.AddOperation(route.method, openAPIPath(prefix+route.path), op)
+	}
+}
+
+// operationID derives a stable ID from the route, e.g. get_user_reports_period
+func operationID(method, path string) string {
+	parts := []string{strings.ToLower(method)}
+	for _, part := range strings.Split(path, "/") {
+		part = strings.Trim(part, ":")
+		part = strings.NewReplacer(".", "_").Replace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// undocumentedRoutes lists the registered routes missing from the document
+func (s *Server) undocumentedRoutes(doc *openapi.Document) []string {
+	var missing []string
+	for _, route := range s.app.GetRoutes(true) {
+		// HEAD is registered along with every GET
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+		if doc.Operation(route.Method, openAPIPath(route.Path)) == nil {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// openAPIDocument builds the OpenAPI document of the routes of the server
+func (s *Server) openAPIDocument() *openapi.Document {
+	root := rootRouteDocs
+	if s.cfg.Metrics.Token != "" {
+		root = append(root[:len(root):len(root)], metricsRouteDoc)
+	}
+	return buildOpenAPI(s.versions(), s.cfg.Server.LegacyRoutes, root)
+}
+
+// registerDocs serves the OpenAPI document and its UI, and warns about the
+// routes missing from it, which openapi_test.go reports
+func (s *Server) registerDocs() {
+	doc := s.openAPIDocument()
+	spec, err := json.Marshal(doc)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode the OpenAPI document: %v", err))
+	}
+
+	s.app.Get("/openapi.json", func(c *fiber.Ctx) error {
+		c.Type("json")
+		return c.Send(spec)
+	})
+	s.app.Get("/docs", func(c *fiber.Ctx) error {
+		c.Type("html", "utf-8")
+		return c.Send(openapi.DocsHTML)
+	})
+
+	if missing := s.undocumentedRoutes(doc); len(missing) > 0 {
+		slog.Warn("Routes missing from the OpenAPI document (server/openapi.go)", "routes", missing)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"API/config"
+	"API/database"
+	"API/mail"
+	"API/reports"
+	"API/storage"
+	"testing"
+)
+
+// TestRoutesDocumented checks that every registered route is in the OpenAPI
+// document, with the optional routes enabled or not
+func TestRoutesDocumented(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *config.Config)
+	}{
+		{"default", func(cfg *config.Config) {}},
+		{"metrics on the API port, no legacy routes", func(cfg *config.Config) {
+			cfg.Metrics.Port = ""
+			cfg.Metrics.Token = "metrics-token"
+			cfg.Server.LegacyRoutes = false
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.configure(&cfg)
+			store := database.NewMemoryStore(nil)
+			blobs := storage.NewLocal(t.TempDir())
+			s := NewServer(cfg, store, reports.NewGenerator(store, blobs, 1), blobs, mail.New(cfg.Mail))
+
+			if missing := s.undocumentedRoutes(s.openAPIDocument()); len(missing) > 0 {
+				t.Errorf("routes missing from the OpenAPI document: %v", missing)
+			}
+		})
+	}
+}
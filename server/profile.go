@@ -0,0 +1,359 @@
+package server
+
+import (
+	"API/database"
+	"API/i18n"
+	"API/models"
+	"API/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+)
+
+// avatarMaxSize bounds the size of the uploaded avatars, in bytes
+const avatarMaxSize = 2 << 20
+
+// avatarTypes gives the extension of the accepted avatar types
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var (
+	errAvatarNotFound = newError(fiber.StatusNotFound, "avatar_not_found", "the user has no avatar")
+	errAvatarTooLarge = newError(fiber.StatusRequestEntityTooLarge, "avatar_too_large", "the avatar must be at most 2 MB")
+	errAvatarType     = newError(fiber.StatusUnsupportedMediaType, "unsupported_avatar_type", "the avatar must be a PNG, JPEG, GIF or WebP image")
+)
+
+// userUpdateRequest is the body of PATCH /user, the omitted fields are kept.
+// A new email is only set once verified.
+type userUpdateRequest struct {
+	Username *string `json:"username" validate:"omitempty,min=3,max=50"`
+	Email    *string `json:"email" validate:"omitempty,email,max=254"`
+}
+
+func (s *Server) updateUserHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req userUpdateRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	ctx := c.UserContext()
+	var user *models.User
+	var token string
+	err := s.store.WithTx(ctx, func(tx *database.Store) error {
+		var err error
+		user, err = tx.Users.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if req.Username != nil && *req.Username != user.Username {
+			user.Username = *req.Username
+			if err := tx.Users.Update(ctx, *user); err != nil {
+				return err
+			}
+		}
+		if req.Email != nil && *req.Email != user.Email {
+			token, err = tx.RequestEmailChange(ctx, userID, *req.Email)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	response := fiber.Map{"message": "user updated", "user": user}
+	if token != "" {
+		// the new email proves it is reachable by sending back the token
+		lang := language(c)
+		subject := i18n.T(lang, "Confirm your new email address")
+		body := i18n.T(lang, "Hello %s,\n\nConfirm your new email address with this code, valid for %d hours:\n\n%s\n\nIf you did not ask for this change, ignore this email.",
+			user.Username, int(database.EmailChangeTTL.Hours()), token)
+		if err := s.mailer.Send(ctx, *req.Email, subject, body); err != nil {
+			return err
+		}
+		response["pending_email"] = *req.Email
+	}
+	return c.JSON(response)
+}
+
+// verifyEmailRequest is the body of POST /user/email/verify, with the token
+// sent to the new email
+type verifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (s *Server) verifyEmailHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req verifyEmailRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	email, err := s.store.ConfirmEmailChange(c.UserContext(), userID, req.Token)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "email updated", "email": email})
+}
+
+// passwordRequest is the body of PUT /user/password
+type passwordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,password"`
+}
+
+func (s *Server) changePasswordHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req passwordRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	if err := s.store.ChangePassword(c.UserContext(), userID, req.CurrentPassword, req.NewPassword); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "password updated"})
+}
+
+func (s *Server) preferencesHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	user, err := s.store.Users.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"preferences": user.Preferences()})
+}
+
+// preferencesRequest is the body of PUT /user/preferences, it replaces all the
+// preferences and the omitted ones get their default: metric units, UTC, the
+// Accept-Language header, no default mode and the default baseline
+type preferencesRequest struct {
+	Units          string `json:"units" validate:"omitempty,oneof=metric imperial"`
+	Timezone       string `json:"timezone" validate:"omitempty,timezone"`
+	Language       string `json:"language" validate:"omitempty,language"`
+	DefaultModeID  int    `json:"default_mode_id" validate:"gte=0"`
+	BaselineModeID int    `json:"baseline_mode_id" validate:"gte=0"`
+}
+
+func (s *Server) setPreferencesHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req preferencesRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	ctx := c.UserContext()
+	user, err := s.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	preferences := models.Preferences{Units: req.Units, Timezone: req.Timezone}
+	if preferences.Units == "" {
+		preferences.Units = models.UnitsMetric
+	}
+	if preferences.Timezone == "" {
+		preferences.Timezone = "UTC"
+	}
+	if req.Language != "" {
+		preferences.Language = &req.Language
+	}
+	// a mode disabled since it was chosen may be kept, not chosen again
+	if preferences.DefaultModeID, err = s.preferredMode(c, "default_mode_id", req.DefaultModeID, user.DefaultModeID); err != nil {
+		return err
+	}
+	if preferences.BaselineModeID, err = s.preferredMode(c, "baseline_mode_id", req.BaselineModeID, user.BaselineModeID); err != nil {
+		return err
+	}
+
+	if err := s.store.Users.UpdatePreferences(ctx, userID, preferences); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "preferences updated", "preferences": preferences})
+}
+
+// preferredMode returns the mode chosen for a preference, nil for 0, checking
+// that it is available unless it is the current one
+func (s *Server) preferredMode(c *fiber.Ctx, field string, modeID int, current *int) (*int, error) {
+	if modeID == 0 {
+		return nil, nil
+	}
+	if current == nil || *current != modeID {
+		if err := s.checkModeAvailable(c.UserContext(), field, modeID); err != nil {
+			return nil, err
+		}
+	}
+	return &modeID, nil
+}
+
+func (s *Server) avatarHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	user, err := s.store.Users.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	if user.AvatarKey == nil {
+		return errAvatarNotFound
+	}
+
+	avatar, err := s.blobs.Get(c.UserContext(), *user.AvatarKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return errAvatarNotFound
+	}
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, mime.TypeByExtension(path.Ext(*user.AvatarKey)))
+	c.Set(fiber.HeaderCacheControl, "private, max-age=3600")
+	return c.SendStream(avatar)
+}
+
+// setAvatarHandler stores the image uploaded as the avatar field of a
+// multipart form and replaces the previous avatar
+func (s *Server) setAvatarHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		return errValidation([]FieldError{fieldError("avatar", "required", "is required")})
+	}
+	if header.Size > avatarMaxSize {
+		return errAvatarTooLarge
+	}
+	file, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	// trust the content, not the name or the type sent by the client
+	ext, ok := avatarTypes[http.DetectContentType(content)]
+	if !ok {
+		return errAvatarType
+	}
+
+	ctx := c.UserContext()
+	user, err := s.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// a new key for each upload, so that cached avatars are never stale
+	key := fmt.Sprintf("avatars/%d-%d%s", userID, time.Now().UnixNano(), ext)
+	if err := s.blobs.Put(ctx, key, bytes.NewReader(content)); err != nil {
+		return err
+	}
+	if err := s.store.Users.UpdateAvatar(ctx, userID, &key); err != nil {
+		s.deleteBlob(ctx, key)
+		return err
+	}
+	if user.AvatarKey != nil {
+		s.deleteBlob(ctx, *user.AvatarKey)
+	}
+
+	return c.JSON(fiber.Map{"message": "avatar updated"})
+}
+
+func (s *Server) deleteAvatarHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	ctx := c.UserContext()
+	user, err := s.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.AvatarKey == nil {
+		return errAvatarNotFound
+	}
+	if err := s.store.Users.UpdateAvatar(ctx, userID, nil); err != nil {
+		return err
+	}
+	s.deleteBlob(ctx, *user.AvatarKey)
+
+	return c.JSON(fiber.Map{"message": "avatar deleted"})
+}
+
+// deleteBlob deletes a blob no longer referenced, a failure only leaves an
+// orphan file behind
+func (s *Server) deleteBlob(ctx context.Context, key string) {
+	if err := s.blobs.Delete(ctx, key); err != nil {
+		slog.WarnContext(ctx, "Error deleting blob", "key", key, "error", err)
+	}
+}
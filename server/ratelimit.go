@@ -0,0 +1,172 @@
+package server
+
+import (
+	"API/config"
+	"API/database"
+	"API/metrics"
+	"API/models"
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginFailureWindow is how long failed logins are remembered, the count
+// starts over after that long without a failure
+const loginFailureWindow = 24 * time.Hour
+
+// limitsPruneInterval is how often the idle buckets and the forgotten failed
+// logins are deleted, which would otherwise pile up for every IP address and
+// email seen
+const limitsPruneInterval = 10 * time.Minute
+
+var (
+	errRateLimited = newError(fiber.StatusTooManyRequests, "rate_limited", "too many requests, retry later")
+	errLoginLocked = newError(fiber.StatusTooManyRequests, "login_locked", "too many failed logins, retry later")
+)
+
+// rateLimit returns a middleware limiting the requests of each key to rate,
+// with a token bucket named name. Rejected requests get a 429 with
+// Retry-After. The requests are let through if the backend fails.
+func (s *Server) rateLimit(name string, rate config.Rate, key func(c *fiber.Ctx) string) fiber.Handler {
+	if !rate.Enabled() {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+	return func(c *fiber.Ctx) error {
+		wait, err := s.store.RateLimits.Take(c.UserContext(), name+":"+key(c), rate.PerSecond(), rate.Count)
+		if err != nil {
+			slog.ErrorContext(c.UserContext(), "Error checking the rate limit", "limit", name, "error", err)
+			return c.Next()
+		}
+		if wait > 0 {
+			metrics.RateLimited.WithLabelValues(name).Inc()
+			setRetryAfter(c, wait)
+			return errRateLimited
+		}
+		return c.Next()
+	}
+}
+
+// clientIP returns the address of the client set by ClientIPMiddleware, it
+// keys the limits by IP address
+func clientIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals("ip").(string); ok {
+		return ip
+	}
+	return c.IP()
+}
+
+// pruneLimits deletes the buckets idle for longer than the longest rate
+// period, full again by then, and the failed logins past their window
+func (s *Server) pruneLimits(ctx context.Context) {
+	limits := s.cfg.RateLimit
+	var idle time.Duration
+	for _, rate := range []config.Rate{limits.IP, limits.User, limits.Auth, limits.Geocoding} {
+		if rate.Enabled() {
+			idle = max(idle, rate.Per)
+		}
+	}
+	if idle > 0 {
+		pruned, err := s.store.RateLimits.Prune(ctx, idle)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error pruning the rate limits", "error", err)
+		} else {
+			slog.DebugContext(ctx, "Rate limits pruned", "buckets", pruned)
+		}
+	}
+	pruned, err := s.store.LoginAttempts.Prune(ctx, loginFailureWindow)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error pruning the login attempts", "error", err)
+	} else {
+		slog.DebugContext(ctx, "Login attempts pruned", "logins", pruned)
+	}
+}
+
+// signedInUser keys the limits by user, after AuthMiddleware
+func signedInUser(c *fiber.Ctx) string {
+	userID, _ := c.Locals("user").(float64)
+	return strconv.Itoa(int(userID))
+}
+
+// setRetryAfter tells the client how many seconds to wait, at least 1
+func setRetryAfter(c *fiber.Ctx, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+}
+
+// loginKeyPrefix returns the start of the login keys of email, shared by
+// every client address
+func loginKeyPrefix(email string) string {
+	return strings.ToLower(strings.TrimSpace(email)) + " "
+}
+
+// checkCredentials checks the credentials unless the email is locked out
+// from the client address after too many failed logins. Each failure past the
+// threshold locks it out twice as long as the previous one, a successful login
+// resets the count.
+//
+// The lockout is keyed by email and address so that anyone can't lock a user
+// out of their account by failing to log in as them. Guessing the password
+// of one email from many addresses is not locked out, and trying many emails
+// from one address is left to the auth rate limit of the address.
+func (s *Server) checkCredentials(c *fiber.Ctx, email, password string) (*models.User, error) {
+	ctx := c.UserContext()
+	limits := s.cfg.RateLimit
+	if limits.LockoutThreshold == 0 {
+		return s.store.CheckUserCredentials(ctx, email, password)
+	}
+
+	key := loginKeyPrefix(email) + clientIP(c)
+	lockedUntil, err := s.store.LoginAttempts.LockedUntil(ctx, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking the login lockout", "error", err)
+	} else if wait := time.Until(lockedUntil); wait > 0 {
+		metrics.RateLimited.WithLabelValues("login_lockout").Inc()
+		setRetryAfter(c, wait)
+		return nil, errLoginLocked
+	}
+
+	user, err := s.store.CheckUserCredentials(ctx, email, password)
+	switch {
+	case errors.Is(err, database.ErrInvalidCredentials):
+		s.recordFailedLogin(c, key)
+	case err == nil:
+		if err := s.store.LoginAttempts.Reset(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "Error resetting the login attempts", "error", err)
+		}
+	}
+	return user, err
+}
+
+func (s *Server) recordFailedLogin(c *fiber.Ctx, key string) {
+	ctx := c.UserContext()
+	limits := s.cfg.RateLimit
+	failures, err := s.store.LoginAttempts.RecordFailure(ctx, key, loginFailureWindow)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording the failed login", "error", err)
+		return
+	}
+	if failures < limits.LockoutThreshold {
+		return
+	}
+
+	lockout := limits.LockoutBase
+	for i := limits.LockoutThreshold; i < failures && lockout < limits.LockoutMax; i++ {
+		lockout *= 2
+	}
+	lockout = min(lockout, limits.LockoutMax)
+	if err := s.store.LoginAttempts.Lock(ctx, key, time.Now().Add(lockout)); err != nil {
+		slog.ErrorContext(ctx, "Error locking the login", "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "Login locked out", "failures", failures, "lockout", lockout.String(), "ip", clientIP(c))
+}
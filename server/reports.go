@@ -0,0 +1,100 @@
+package server
+
+import (
+	"API/i18n"
+	"API/reports"
+	"API/storage"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s *Server) requestReportHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	period := c.Params("period")
+	if _, _, err := reports.ParsePeriod(period, userLocation(c)); err != nil {
+		return invalidParameter("invalid period: %s", period)
+	}
+
+	// (re)generate the report in the background
+	job, err := s.reports.Request(c.UserContext(), userID, period, language(c))
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"report": localizeJob(job, language(c))})
+}
+
+func (s *Server) reportHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	period := c.Params("period")
+	if _, _, err := reports.ParsePeriod(period, userLocation(c)); err != nil {
+		return invalidParameter("invalid period: %s", period)
+	}
+
+	format := c.Query("format", "pdf")
+	if format != "pdf" && format != "html" {
+		return invalidParameter("format must be pdf or html")
+	}
+
+	// the first download request starts the generation
+	ctx := c.UserContext()
+	job, ok, err := s.reports.Get(ctx, userID, period)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if job, err = s.reports.Request(ctx, userID, period, language(c)); err != nil {
+			return err
+		}
+	}
+
+	switch job.Status {
+	case reports.StatusPending:
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"report": localizeJob(job, language(c))})
+	case reports.StatusFailed:
+		return newError(fiber.StatusInternalServerError, "report_failed", job.Error)
+	}
+
+	file, err := s.reports.Open(ctx, userID, period, format)
+	if errors.Is(err, storage.ErrNotFound) {
+		// the files were removed from the storage, generate them again
+		if job, err = s.reports.Request(ctx, userID, period, language(c)); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"report": localizeJob(job, language(c))})
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Attachment(fmt.Sprintf("report-%s.%s", period, format))
+	if format == "html" {
+		c.Type("html", "utf-8")
+	} else {
+		c.Type("pdf")
+	}
+	return c.SendStream(file)
+}
+
+// localizeJob translates the error of the job to lang
+func localizeJob(job reports.Job, lang string) reports.Job {
+	if job.Error != "" {
+		job.Error = i18n.T(lang, job.Error)
+	}
+	return job
+}
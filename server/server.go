@@ -1,80 +1,281 @@
 package server
 
 import (
+	"API/config"
 	"API/database"
+	"API/i18n"
+	"API/mail"
+	"API/models"
+	"API/reports"
+	"API/storage"
+	"API/units"
+	"context"
+	"errors"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"os"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"log/slog"
+	"net/http"
 	"strconv"
 	"time"
 )
 
-func StartAndInitializeServer() {
+// Server holds the dependencies of the HTTP handlers
+type Server struct {
+	app     *fiber.App
+	cfg     config.Config
+	store   *database.Store
+	reports *reports.Generator
+	// blobs stores the avatars and mailer sends the confirmation emails
+	blobs  storage.Blobs
+	mailer mail.Sender
+	// routes holds the "METHOD path" of the registered routes
+	routes map[string]bool
+}
+
+// NewServer creates the Fiber app and registers the routes on it
+func NewServer(cfg config.Config, store *database.Store, reportGenerator *reports.Generator, blobs storage.Blobs, mailer mail.Sender) *Server {
+	s := &Server{
+		app: fiber.New(fiber.Config{
+			ErrorHandler:          ErrorHandler,
+			DisableStartupMessage: true,
+		}),
+		cfg:     cfg,
+		store:   store,
+		reports: reportGenerator,
+		blobs:   blobs,
+		mailer:  mailer,
+	}
+	s.registerRoutes()
+	return s
+}
+
+// App returns the Fiber app, e.g. to serve requests with app.Test
+func (s *Server) App() *fiber.App {
+	return s.app
+}
+
+// runEvery runs fn now and then every interval until ctx is done
+func runEvery(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StartAndInitializeServer serves the API until ctx is done, on SIGTERM, then
+// drains the in-flight requests and the queued reports within the shutdown
+// timeout. It returns an error if the blob storage cannot be set up or the
+// API port cannot be listened on.
+func StartAndInitializeServer(ctx context.Context, cfg config.Config, store *database.Store) error {
+
+	// Store the avatars and the reports in the configured backend
+	blobs, err := storage.New(cfg.Storage)
+	if err != nil {
+		return err
+	}
+
+	// Start the background report workers
+	reportGenerator := reports.NewGenerator(store, blobs, cfg.Server.ReportWorkers)
+
+	// Initialize the server
+	s := NewServer(cfg, store, reportGenerator, blobs, mail.New(cfg.Mail))
+
+	// Delete the accounts past their grace period, and the idle rate limits
+	go runEvery(ctx, accountPurgeInterval, s.purgeAccounts)
+	go runEvery(ctx, limitsPruneInterval, s.pruneLimits)
+
+	// Serve the metrics on the admin port
+	var metricsServer *http.Server
+	if cfg.Metrics.Port != "" {
+		metricsServer = newMetricsServer(cfg.Metrics.Port)
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Metrics server stopped", "error", err)
+			}
+		}()
+	}
 
-	// Initialize Fiber app
-	app := fiber.New()
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- s.app.Listen(":" + cfg.Server.Port)
+	}()
+	select {
+	case err := <-listenErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting requests and wait for the in-flight ones, then for the
+	// reports, all within the same deadline
+	slog.Info("Shutting down", "timeout", cfg.Server.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := s.app.ShutdownWithContext(shutdownCtx); err != nil {
+		slog.Error("Error draining the requests", "error", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error stopping the metrics server", "error", err)
+		}
+	}
+	if err := reportGenerator.Stop(shutdownCtx); err != nil {
+		slog.Error("Error finishing the queued reports", "error", err)
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+func (s *Server) registerRoutes() {
+	app := s.app
+
+	// Give each request an ID, echoed in X-Request-ID and in the error bodies
+	app.Use(requestid.New())
+
+	// Find the address of the client behind the load balancer
+	app.Use(ClientIPMiddleware(s.cfg.Server.ProxyHeader, s.cfg.Server.ProxyHops))
+
+	// Trace, measure and log every request
+	app.Use(TracingMiddleware)
+	app.Use(s.MetricsMiddleware)
+	app.Use(LoggingMiddleware)
 
 	// Enable CORS
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
+		AllowOrigins:  "*",
+		ExposeHeaders: "X-Request-ID, Deprecation, Sunset, Link",
 	}))
 
-	// Register routes
-	app.Post("/register", registerHandler)
+	// Answer in the language of the client
+	app.Use(LanguageMiddleware)
 
-	// Auth routes
-	auth := app.Group("/auth")
-	auth.Post("/login", loginHandler)
-	auth.Post("/login/cookie", loginCookieHandler)
-
-	users := app.Group("/user")
-	users.Use(AuthMiddleware)
-	users.Get("/info", userInfoHandler)
-
-	trips := app.Group("/trips")
-	trips.Use(AuthMiddleware)
-	trips.Get("/", tripsHandler)
-	trips.Post("/", createTripHandler)
-	trips.Get("/impactgraphday", tripsImpactGraphDayHandler)
-	trips.Get("/impactgraphmonth", tripsImpactGraphMonthHandler)
-	trips.Get("/aggregation", tripsAggregationHandler)
-	trips.Get("/impact", totalImpactHandler)
+	// Bound the work done for each request
+	app.Use(TimeoutMiddleware(s.cfg.Server.RequestTimeout))
 
-	// Transport modes routes
-	transportation := app.Group("/transportation")
-	transportation.Get("/", transportationModesHandler)
-	transportation.Get("/:mode_id", transportationModeHandler)
+	// Liveness and readiness checks, registered before the rate limit so that
+	// the health checks of the platform are never limited
+	s.registerHealth()
 
-	// read port from environment variable
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
-	}
+	// Limit the requests of each IP address
+	app.Use(s.rateLimit("ip", s.cfg.RateLimit.IP, clientIP))
 
-	log.Fatal(app.Listen(":" + port))
+	// Mount each version of the API and the legacy root aliases
+	s.registerVersions()
 
+	// Prometheus metrics, when they are served on the API port
+	s.registerMetrics()
+
+	// OpenAPI document and docs UI, registered last to check every route is documented
+	s.registerDocs()
+	s.indexRoutes()
+}
+
+// v1Routes registers the routes of the version 1 of the API
+func (s *Server) v1Routes(r fiber.Router) {
+	authLimit := s.rateLimit("auth", s.cfg.RateLimit.Auth, clientIP)
+	userLimit := s.rateLimit("user", s.cfg.RateLimit.User, signedInUser)
+	// the routes that may geocode addresses, a paid API
+	geocodingLimit := s.rateLimit("geocoding", s.cfg.RateLimit.Geocoding, signedInUser)
+
+	r.Post("/register", authLimit, s.registerHandler)
+
+	// Auth routes
+	auth := r.Group("/auth")
+	auth.Post("/login", authLimit, s.loginHandler)
+	auth.Post("/login/cookie", authLimit, s.loginCookieHandler)
+
+	users := r.Group("/user")
+	users.Use(s.AuthMiddleware, userLimit)
+	users.Get("/info", s.userInfoHandler)
+	users.Patch("/", s.updateUserHandler)
+	users.Get("/export", s.exportHandler)
+	users.Get("/deletion", s.deletionHandler)
+	users.Post("/deletion", s.requestDeletionHandler)
+	users.Post("/deletion/confirm", s.confirmDeletionHandler)
+	users.Delete("/deletion", s.cancelDeletionHandler)
+	users.Post("/email/verify", s.verifyEmailHandler)
+	users.Put("/password", s.changePasswordHandler)
+	users.Get("/preferences", s.preferencesHandler)
+	users.Put("/preferences", s.setPreferencesHandler)
+	users.Get("/avatar", s.avatarHandler)
+	users.Put("/avatar", s.setAvatarHandler)
+	users.Delete("/avatar", s.deleteAvatarHandler)
+	users.Put("/baseline", s.userBaselineHandler)
+	users.Put("/language", s.userLanguageHandler)
+	users.Get("/budget", s.budgetHandler)
+	users.Put("/budget", s.setBudgetHandler)
+	users.Delete("/budget", s.deleteBudgetHandler)
+	users.Get("/notifications", s.notificationsHandler)
+	users.Post("/notifications/:notification_id/read", s.readNotificationHandler)
+	users.Get("/reports/:period", s.reportHandler)
+	users.Post("/reports/:period", s.requestReportHandler)
+
+	trips := r.Group("/trips")
+	trips.Use(s.AuthMiddleware, userLimit)
+	trips.Get("/", s.tripsHandler)
+	trips.Post("/", geocodingLimit, s.createTripHandler)
+	trips.Get("/impactgraphday", s.tripsImpactGraphDayHandler)
+	trips.Get("/impactgraphmonth", s.tripsImpactGraphMonthHandler)
+	trips.Get("/aggregation", s.tripsAggregationHandler)
+	trips.Get("/impact", s.totalImpactHandler)
+	trips.Get("/compare", geocodingLimit, s.tripsCompareHandler)
+	trips.Get("/savings", s.totalSavingsHandler)
+	trips.Get("/savings/monthly", s.monthlySavingsHandler)
+	trips.Get("/forecast", s.tripsForecastHandler)
+
+	// Transport modes routes
+	transportation := r.Group("/transportation")
+	transportation.Get("/", s.transportationModesHandler)
+	transportation.Get("/:mode_id", s.transportationModeHandler)
+
+	// Admin routes, for the moderators and some for the admins only
+	admin := r.Group("/admin")
+	admin.Use(s.AuthMiddleware, userLimit, RequireRole(models.RoleModerator))
+	adminOnly := RequireRole(models.RoleAdmin)
+	admin.Get("/users", s.adminUsersHandler)
+	admin.Get("/users/:user_id", s.adminUserHandler)
+	admin.Post("/users/:user_id/disable", s.adminDisableHandler(true))
+	admin.Post("/users/:user_id/enable", s.adminDisableHandler(false))
+	admin.Put("/users/:user_id/role", adminOnly, s.adminRoleHandler)
+	admin.Delete("/users/:user_id", adminOnly, s.adminDeleteUserHandler)
+	admin.Get("/modes", adminOnly, s.adminModesHandler)
+	admin.Post("/modes", adminOnly, s.adminCreateModeHandler)
+	admin.Put("/modes/:mode_id", adminOnly, s.adminUpdateModeHandler)
+	admin.Delete("/modes/:mode_id", adminOnly, s.adminDeleteModeHandler)
+	admin.Get("/challenges", s.adminChallengesHandler)
+	admin.Post("/challenges", s.adminCreateChallengeHandler)
+	admin.Put("/challenges/:challenge_id", s.adminUpdateChallengeHandler)
+	admin.Delete("/challenges/:challenge_id", s.adminDeleteChallengeHandler)
+	admin.Get("/audit", adminOnly, s.adminAuditHandler)
 }
 
 type Point struct {
-	X int     `json:"x"`
-	Y float64 `json:"y"`
+	X       int     `json:"x"`
+	Y       float64 `json:"y"`
+	Avoided float64 `json:"avoided"`
 }
 
-func userInfoHandler(c *fiber.Ctx) error {
+func (s *Server) userInfoHandler(c *fiber.Ctx) error {
 	// Get user ID from JWT
 	temp := c.Locals("user").(float64)
 	userID := int(temp)
 
 	if userID == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id is required"})
+		return errUserIDRequired
 	}
 
 	// Get user info
-	user, err := database.GetUser(userID)
+	user, err := s.store.Users.GetByID(c.UserContext(), userID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 	user.PasswordHash = ""
 	user.GoogleID = nil
@@ -83,202 +284,462 @@ func userInfoHandler(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"user": user})
 }
 
-func tripsImpactGraphDayHandler(c *fiber.Ctx) error {
+// baselineRequest is the body of PUT /user/baseline, 0 resets the baseline
+type baselineRequest struct {
+	ModeID int `json:"mode_id" validate:"gte=0"`
+}
+
+func (s *Server) userBaselineHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req baselineRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	// if mode ID is 0 reset to the default baseline
+	if req.ModeID == 0 {
+		req.ModeID = database.DefaultBaselineModeID
+	}
+
+	// the baseline must be a known transportation mode
+	if err := s.checkModeAvailable(c.UserContext(), "mode_id", req.ModeID); err != nil {
+		return err
+	}
+
+	if err := s.store.Users.UpdateBaselineMode(c.UserContext(), userID, req.ModeID); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "baseline updated", "baseline_mode_id": req.ModeID})
+}
+
+// languageRequest is the body of PUT /user/language, an empty language
+// follows the Accept-Language header again
+type languageRequest struct {
+	Language string `json:"language" validate:"omitempty,language"`
+}
+
+func (s *Server) userLanguageHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req languageRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	var lang *string
+	if req.Language != "" {
+		lang = &req.Language
+	}
+	if err := s.store.Users.UpdateLanguage(c.UserContext(), userID, lang); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "language updated", "language": req.Language})
+}
+
+// userLocation returns the time zone of the user set by AuthMiddleware, the
+// trips are bucketed by day and month in it
+func userLocation(c *fiber.Ctx) *time.Location {
+	if loc, ok := c.Locals("location").(*time.Location); ok {
+		return loc
+	}
+	return time.UTC
+}
+
+func (s *Server) tripsImpactGraphDayHandler(c *fiber.Ctx) error {
 	// 1 year graph with 1 datapoint per day
 
 	temp := c.Locals("user").(float64)
 	userID := int(temp)
-	trips, err := database.GetUserTrips(userID)
+	system, err := unitSystem(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+	trips, err := s.store.GetUserTrips(c.UserContext(), userID)
+	if err != nil {
+		return err
 	}
 
+	loc := userLocation(c)
 	points := make([]Point, 366)
 	for _, trip := range trips {
 		// if the trip date is within the last year
 		if trip.TripDate.After(time.Now().AddDate(-1, 0, 0)) {
-			points[trip.TripDate.YearDay()].Y += *trip.CarbonImpactKg
-			points[trip.TripDate.YearDay()].X = trip.TripDate.YearDay()
+			// day 366 of the leap years is the last point
+			day := trip.TripDate.In(loc).YearDay() - 1
+			points[day].Y += *trip.CarbonImpactKg
+			points[day].Avoided += database.TripAvoidedImpact(trip)
+			points[day].X = day + 1
 
 		}
 	}
 
 	// now that we have the impact for each day cascade the values to have a cumulative impact
 	newPoints := make([]Point, 366)
-	var sum float64
+	var sum, avoided float64
 	for i, point := range points {
 		sum += point.Y
+		avoided += point.Avoided
 		newPoints[i].Y = sum
+		newPoints[i].Avoided = avoided
 		newPoints[i].X = i + 1
 	}
 
-	return c.JSON(fiber.Map{"points": newPoints})
+	return c.JSON(fiber.Map{"points": convertPoints(newPoints, system), "units": units.Of(system)})
 
 }
 
-func tripsImpactGraphMonthHandler(c *fiber.Ctx) error {
+func (s *Server) tripsImpactGraphMonthHandler(c *fiber.Ctx) error {
 	// 1 year graph with 1 datapoint per month
 
 	temp := c.Locals("user").(float64)
 	userID := int(temp)
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
+	}
 
-	trips, err := database.GetUserTrips(userID)
+	trips, err := s.store.GetUserTrips(c.UserContext(), userID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
+	loc := userLocation(c)
 	points := make([]Point, 13)
 	for _, trip := range trips {
 		// if the trip date is within the last year
 		if trip.TripDate.After(time.Now().AddDate(-1, 0, 0)) {
-			points[trip.TripDate.Month()].Y += *trip.CarbonImpactKg
-			points[trip.TripDate.Month()].X = int(trip.TripDate.Month())
+			month := trip.TripDate.In(loc).Month()
+			points[month].Y += *trip.CarbonImpactKg
+			points[month].Avoided += database.TripAvoidedImpact(trip)
+			points[month].X = int(month)
 		}
 	}
 	// now that we have the impact for each month cascade the values to have a cumulative impact
 	newPoints := make([]Point, 13)
-	var sum float64
+	var sum, avoided float64
 	for i, point := range points {
 		sum += point.Y
+		avoided += point.Avoided
 		newPoints[i].Y = sum
+		newPoints[i].Avoided = avoided
 		newPoints[i].X = i + 1
 	}
 
-	return c.JSON(fiber.Map{"points": newPoints})
+	return c.JSON(fiber.Map{"points": convertPoints(newPoints, system), "units": units.Of(system)})
 }
 
-func totalImpactHandler(c *fiber.Ctx) error {
+func (s *Server) totalImpactHandler(c *fiber.Ctx) error {
 	// Get user ID from JWT
 	temp := c.Locals("user").(float64)
 	userID := int(temp)
 
 	if userID == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id is required"})
+		return errUserIDRequired
+	}
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
 	}
 
 	// Get total carbon impact for the user
-	totalImpact, err := database.TotalCarbonImpact(userID)
+	totalImpact, err := s.store.TotalCarbonImpact(c.UserContext(), userID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
-	return c.JSON(fiber.Map{"total_impact": totalImpact})
+	return c.JSON(fiber.Map{"total_impact": units.Mass(totalImpact, system), "units": units.Of(system)})
 }
-func createTripHandler(c *fiber.Ctx) error {
-	// Parse request body
-	var req struct {
-		StartAddress string  `json:"start_address"`
-		EndAddress   string  `json:"end_address"`
-		CarBrand     string  `json:"car_brand"`
-		CarModel     string  `json:"car_model"`
-		DistanceKm   float64 `json:"distance_km"`
-		ModeID       int     `json:"mode_id"`
-		TripDate     string  `json:"trip_date"`
+
+func (s *Server) totalSavingsHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
 	}
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+
+	// Get emitted and avoided carbon for the user
+	savings, err := s.store.TotalCarbonSavings(c.UserContext(), userID)
+	if err != nil {
+		return err
 	}
 
+	return c.JSON(fiber.Map{"savings": convertSavings(savings, system), "units": units.Of(system)})
+}
+
+func (s *Server) monthlySavingsHandler(c *fiber.Ctx) error {
 	// Get user ID from JWT
 	temp := c.Locals("user").(float64)
 	userID := int(temp)
 
 	if userID == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id is required"})
+		return errUserIDRequired
+	}
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
 	}
 
-	// if distance is 0, and start and end address not provided return error
-	if req.DistanceKm == 0 && (req.StartAddress == "" && req.EndAddress == "") {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "no distance or address provided"})
+	// Get emitted and avoided carbon for each month
+	savings, err := s.store.MonthlyCarbonSavings(c.UserContext(), userID)
+	if err != nil {
+		return err
 	}
 
-	// if mode ID is 0 return error
-	if req.ModeID == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mode_id is required"})
+	for i := range savings {
+		savings[i] = convertSavings(savings[i], system)
+	}
+	return c.JSON(fiber.Map{"savings": savings, "units": units.Of(system)})
+}
+
+// maxTripDistanceKm bounds the distance of a recorded trip
+const maxTripDistanceKm = 20000
+
+// createTripRequest is the body of POST /trips, either the distance or both addresses are needed.
+// The distance is given in km, or in the unit system of the request.
+type createTripRequest struct {
+	StartAddress string  `json:"start_address" validate:"required_without_all=DistanceKm Distance,max=255"`
+	EndAddress   string  `json:"end_address" validate:"required_without_all=DistanceKm Distance,max=255"`
+	CarBrand     string  `json:"car_brand" validate:"max=100"`
+	CarModel     string  `json:"car_model" validate:"max=100"`
+	DistanceKm   float64 `json:"distance_km" validate:"gte=0,lte=20000"`
+	Distance     float64 `json:"distance" validate:"gte=0,excluded_with=DistanceKm"`
+	ModeID       int     `json:"mode_id" validate:"required,gt=0"`
+	TripDate     string  `json:"trip_date" validate:"omitempty,datetime"`
+}
+
+func (s *Server) createTripHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req createTripRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+
+	// the distance in the unit system of the request is stored in km
+	if req.Distance != 0 {
+		system, err := unitSystem(c)
+		if err != nil {
+			return err
+		}
+		req.DistanceKm = units.ToKm(req.Distance, system)
+		if req.DistanceKm > maxTripDistanceKm {
+			limit := strconv.FormatFloat(units.Distance(maxTripDistanceKm, system), 'f', 0, 64)
+			return errValidation([]FieldError{fieldError("distance", "lte", "must be less than or equal to %s", limit)})
+		}
+	}
+
+	if err := s.checkModeAvailable(c.UserContext(), "mode_id", req.ModeID); err != nil {
+		return err
 	}
 
 	// Register trip in the database
-	err := database.RegisterTrip(req.StartAddress, req.EndAddress, req.CarBrand, req.CarModel, req.DistanceKm, req.ModeID, userID, req.TripDate)
+	err := s.store.RegisterTrip(c.UserContext(), req.StartAddress, req.EndAddress, req.CarBrand, req.CarModel, req.DistanceKm, req.ModeID, userID, req.TripDate, language(c))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
 	return c.JSON(fiber.Map{"message": "trip registered"})
 
 }
 
-func tripsAggregationHandler(c *fiber.Ctx) error {
+// compareQuery holds the query parameters of GET /trips/compare, either the distance or both addresses are needed
+type compareQuery struct {
+	StartAddress string  `query:"start_address" validate:"required_without_all=DistanceKm Distance,max=255"`
+	EndAddress   string  `query:"end_address" validate:"required_without_all=DistanceKm Distance,max=255"`
+	DistanceKm   float64 `query:"distance_km" validate:"gte=0,lte=20000"`
+	Distance     float64 `query:"distance" validate:"gte=0,excluded_with=DistanceKm"`
+	Duration     bool    `query:"duration"`
+}
+
+func (s *Server) tripsCompareHandler(c *fiber.Ctx) error {
+	// Parse query parameters
+	var req compareQuery
+	if err := parseQuery(c, &req); err != nil {
+		return err
+	}
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
+	}
+
+	// the distance in the unit system of the request is compared in km
+	if req.Distance != 0 {
+		req.DistanceKm = units.ToKm(req.Distance, system)
+		if req.DistanceKm > maxTripDistanceKm {
+			limit := strconv.FormatFloat(units.Distance(maxTripDistanceKm, system), 'f', 0, 64)
+			return errValidation([]FieldError{fieldError("distance", "lte", "must be less than or equal to %s", limit)})
+		}
+	}
+
+	distanceKm, modes, err := s.store.CompareTripModes(c.UserContext(), language(c), req.StartAddress, req.EndAddress, req.DistanceKm, req.Duration)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"distance_km": distanceKm, "distance": units.Distance(distanceKm, system),
+		"modes": convertModeComparisons(modes, system), "units": units.Of(system)})
+}
+
+func (s *Server) tripsForecastHandler(c *fiber.Ctx) error {
+	// Get user ID from JWT
+	temp := c.Locals("user").(float64)
+	userID := int(temp)
+
+	if userID == 0 {
+		return errUserIDRequired
+	}
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
+	}
+
+	// number of months to forecast, 3 by default
+	months := c.QueryInt("months", 3)
+	if months < 1 || months > 12 {
+		return invalidParameter("months must be between 1 and 12")
+	}
+
+	method := c.Query("method", database.ForecastMethodAuto)
+	if method != database.ForecastMethodAuto && method != database.ForecastMethodSeasonalNaive && method != database.ForecastMethodExponentialSmoothing {
+		return invalidParameter("invalid method")
+	}
+
+	forecast, err := s.store.ForecastUserEmissions(c.UserContext(), userID, method, months)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"forecast": convertForecast(forecast, system), "units": units.Of(system)})
+}
+
+func (s *Server) tripsAggregationHandler(c *fiber.Ctx) error {
 	// Get user ID from JWT
 	temp := c.Locals("user").(float64)
 	userID := int(temp)
 
 	if userID == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id is required"})
+		return errUserIDRequired
+	}
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
 	}
 
 	// Get aggregated trips for the user
-	trips, err := database.AggregateUserTripsByMode(userID)
+	trips, err := s.store.AggregateUserTripsByMode(c.UserContext(), userID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
-	return c.JSON(fiber.Map{"trips": trips})
+	return c.JSON(fiber.Map{"trips": convertTripsByMode(trips, system), "units": units.Of(system)})
 
 }
 
-func tripsHandler(c *fiber.Ctx) error {
+func (s *Server) tripsHandler(c *fiber.Ctx) error {
 	// Get user ID from JWT
 	temp := c.Locals("user").(float64)
 	userID := int(temp)
 
 	if userID == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id is required"})
+		return errUserIDRequired
+	}
+	system, err := unitSystem(c)
+	if err != nil {
+		return err
 	}
 
 	// Get all trips for the user
-	trips, err := database.GetUserTrips(userID)
+	trips, err := s.store.GetUserTrips(c.UserContext(), userID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
-	return c.JSON(fiber.Map{"trips": trips})
+	return c.JSON(fiber.Map{"trips": convertTrips(trips, system, userLocation(c)), "units": units.Of(system)})
 
 }
 
-func transportationModeHandler(c *fiber.Ctx) error {
+func (s *Server) transportationModeHandler(c *fiber.Ctx) error {
 	// Get mode ID from URL
 	modeID := c.Params("mode_id")
 	if modeID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mode_id is required"})
+		return invalidParameter("%s is required", "mode_id")
 	}
 
 	// Convert mode ID to integer
 	modeIDInt, err := strconv.Atoi(modeID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid mode_id"})
+		return invalidParameter("invalid %s", "mode_id")
 	}
 
 	// Get transportation mode by ID
-	mode, err := database.GetTransportationModeByID(modeIDInt)
+	mode, err := s.store.Modes.GetByID(c.UserContext(), modeIDInt)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
-	return c.JSON(fiber.Map{"mode": mode})
+	return c.JSON(fiber.Map{"mode": localizeMode(mode, language(c))})
 
 }
 
-func transportationModesHandler(c *fiber.Ctx) error {
+func (s *Server) transportationModesHandler(c *fiber.Ctx) error {
 	// Get all transportation modes
-	modes, err := database.GetAllTransportationModes()
+	modes, err := s.store.Modes.GetAll(c.UserContext())
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	// the disabled modes stay readable by ID, for the trips made with them
+	enabled := []*models.TransportationMode{}
+	for _, mode := range modes {
+		if mode.Enabled {
+			enabled = append(enabled, localizeMode(mode, language(c)))
+		}
 	}
 
-	return c.JSON(fiber.Map{"modes": modes})
+	return c.JSON(fiber.Map{"modes": enabled})
+}
+
+// localizeMode sets the name and the description of the mode in the language
+// lang, when translated
+func localizeMode(mode *models.TransportationMode, lang string) *models.TransportationMode {
+	mode.ModeName = i18n.Pick(lang, mode.Names, mode.ModeName)
+	if description := i18n.Pick(lang, mode.Descriptions, ""); description != "" {
+		mode.Description = &description
+	}
+	return mode
 }
 
-func AuthMiddleware(c *fiber.Ctx) error {
+func (s *Server) AuthMiddleware(c *fiber.Ctx) error {
 	// Get JWT from cookie or Authorization header
 	jwtCookie := c.Cookies("jwt")
 	if jwtCookie == "" {
@@ -289,108 +750,151 @@ func AuthMiddleware(c *fiber.Ctx) error {
 		}
 	}
 	if jwtCookie == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		return errUnauthorized
 	}
 
 	// Parse JWT
 	token, err := jwt.Parse(jwtCookie, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
+		return []byte(s.cfg.Auth.JWTSecret), nil
 	})
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		return errUnauthorized
 	}
 
 	// Check if token is valid
 	if !token.Valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		return errUnauthorized
 	}
 	c.Locals("user", token.Claims.(jwt.MapClaims)["user_id"])
 
+	// The account is read on each request so that disabling it or changing
+	// its role applies to the tokens already issued
+	userID, _ := c.Locals("user").(float64)
+	user, err := s.store.Users.GetByID(c.UserContext(), int(userID))
+	if errors.Is(err, database.ErrUserNotFound) {
+		return errUnauthorized
+	}
+	if err != nil {
+		return err
+	}
+	if user.DisabledAt != nil {
+		return database.ErrAccountDisabled
+	}
+	c.Locals("role", user.Role)
+	c.Locals("units", user.Units)
+	c.Locals("location", user.Location())
+	if user.Language != nil && i18n.Supported(*user.Language) {
+		setLanguage(c, *user.Language)
+	}
+
 	return c.Next()
 }
 
-var jwtSecret = []byte("your_secret_key")
+// roleRanks orders the roles, each one has the permissions of the lower ones
+var roleRanks = map[string]int{models.RoleUser: 0, models.RoleModerator: 1, models.RoleAdmin: 2}
 
-func registerHandler(c *fiber.Ctx) error {
-	// Parse request body
-	var req struct {
-		Email    string `json:"email"`
-		Username string `json:"username"`
-		Password string `json:"password"`
+// hasRole reports whether role has the permissions of required
+func hasRole(role, required string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[required]
+}
+
+// RequireRole lets through the users with at least the given role. It
+// complements AuthMiddleware, which must run first.
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if current, _ := c.Locals("role").(string); !hasRole(current, role) {
+			return database.ErrForbidden
+		}
+		return c.Next()
 	}
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+}
+
+// registerRequest is the body of POST /register
+type registerRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Username string `json:"username" validate:"required,min=3,max=50"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+func (s *Server) registerHandler(c *fiber.Ctx) error {
+	// Parse request body
+	var req registerRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
 	}
 
 	// Register user in the database
-	userID, err := database.RegisterUserFromEmail(req.Email, req.Username, req.Password)
+	userID, err := s.store.RegisterUserFromEmail(c.UserContext(), req.Email, req.Username, req.Password)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "user registered", "user_id": userID})
 }
 
-func loginHandler(c *fiber.Ctx) error {
+// loginRequest is the body of the login routes
+type loginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (s *Server) loginHandler(c *fiber.Ctx) error {
 	// Parse request body
-	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	var req loginRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
 	}
 
-	// Validate user credentials
-	userID, err := database.CheckUserCredentials(req.Email, req.Password)
+	// Validate user credentials, unless locked out
+	user, err := s.checkCredentials(c, req.Email, req.Password)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
 	// Generate JWT
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		"user_id": user.UserID,
+		"role":    user.Role,
+		"exp":     time.Now().Add(s.cfg.Auth.TokenTTL).Unix(),
 	})
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := token.SignedString([]byte(s.cfg.Auth.JWTSecret))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate token"})
+		return errTokenGeneration
 	}
 
 	return c.JSON(fiber.Map{"token": tokenString})
 }
 
-func loginCookieHandler(c *fiber.Ctx) error {
+func (s *Server) loginCookieHandler(c *fiber.Ctx) error {
 	// Parse request body
-	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	var req loginRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
 	}
 
-	// Validate user credentials
-	userID, err := database.CheckUserCredentials(req.Email, req.Password)
+	// Validate user credentials, unless locked out
+	user, err := s.checkCredentials(c, req.Email, req.Password)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
 	// Generate JWT
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		"user_id": user.UserID,
+		"role":    user.Role,
+		"exp":     time.Now().Add(s.cfg.Auth.TokenTTL).Unix(),
 	})
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := token.SignedString([]byte(s.cfg.Auth.JWTSecret))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate token"})
+		return errTokenGeneration
 	}
 
 	// Set JWT as a cookie
 	c.Cookie(&fiber.Cookie{
 		Name:     "jwt",
 		Value:    tokenString,
-		Expires:  time.Now().Add(time.Hour * 24),
+		Expires:  time.Now().Add(s.cfg.Auth.TokenTTL),
 		HTTPOnly: true,
 	})
 	// redirect to the home page
@@ -0,0 +1,434 @@
+package server
+
+import (
+	"API/config"
+	"API/database"
+	"API/mail"
+	"API/models"
+	"API/reports"
+	"API/storage"
+	"API/units"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testModes are the modes of the test store, priced by their emission factor
+// so that no provider is called
+func testModes() []models.TransportationMode {
+	factor := func(kgPerKm float64) *float64 { return &kgPerKm }
+	return []models.TransportationMode{
+		{ModeID: 1, ModeName: "Bike", Category: "active", EmissionFactorKgPerKm: factor(0), Enabled: true},
+		{ModeID: database.DefaultBaselineModeID, ModeName: "Car", Category: "car", EmissionFactorKgPerKm: factor(0.2), Enabled: true},
+	}
+}
+
+// newTestServer returns a server on a memory store holding testModes
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "test-secret-of-at-least-32-bytes!!"
+	store := database.NewMemoryStore(nil, testModes()...)
+	blobs := storage.NewLocal(t.TempDir())
+	return NewServer(cfg, store, reports.NewGenerator(store, blobs, 1), blobs, mail.New(cfg.Mail))
+}
+
+// do sends the request with body encoded as JSON, signed with token unless
+// it is empty, and decodes the JSON response into out unless it is nil
+func do(t *testing.T, s *Server, method, path, token string, body, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.App().Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding the response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// signUp registers a user and returns its token
+func signUp(t *testing.T, s *Server, email string) string {
+	t.Helper()
+	user := map[string]string{"email": email, "username": email[:4], "password": "correct-horse-1"}
+	if status := do(t, s, "POST", "/v1/register", "", user, nil); status != fiber.StatusCreated {
+		t.Fatalf("register: status %d", status)
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	if status := do(t, s, "POST", "/v1/auth/login", "", map[string]string{"email": email, "password": "correct-horse-1"}, &login); status != fiber.StatusOK {
+		t.Fatalf("login: status %d", status)
+	}
+	return login.Token
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	s := newTestServer(t)
+	signUp(t, s, "alice@example.com")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   map[string]string
+		status int
+		code   string
+	}{
+		{"email taken", "POST", "/v1/register", map[string]string{"email": "alice@example.com", "username": "alice2", "password": "correct-horse-1"}, fiber.StatusConflict, "email_exists"},
+		{"weak password", "POST", "/v1/register", map[string]string{"email": "bob@example.com", "username": "bob", "password": "short"}, fiber.StatusUnprocessableEntity, "validation_failed"},
+		{"wrong password", "POST", "/v1/auth/login", map[string]string{"email": "alice@example.com", "password": "wrong-horse-1"}, fiber.StatusUnauthorized, "invalid_credentials"},
+		{"unknown email", "POST", "/v1/auth/login", map[string]string{"email": "bob@example.com", "password": "correct-horse-1"}, fiber.StatusUnauthorized, "invalid_credentials"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var problem Problem
+			status := do(t, s, tt.method, tt.path, "", tt.body, &problem)
+			if status != tt.status || problem.Code != tt.code {
+				t.Errorf("got %d %q, want %d %q", status, problem.Code, tt.status, tt.code)
+			}
+		})
+	}
+
+	var problem Problem
+	if status := do(t, s, "GET", "/v1/trips", "", nil, &problem); status != fiber.StatusUnauthorized {
+		t.Errorf("trips without a token: status %d, want 401", status)
+	}
+}
+
+func TestTrips(t *testing.T) {
+	s := newTestServer(t)
+	token := signUp(t, s, "alice@example.com")
+
+	for _, trip := range []map[string]interface{}{
+		{"distance_km": 10, "mode_id": database.DefaultBaselineModeID, "trip_date": "2026-03-02"},
+		{"distance_km": 5, "mode_id": 1, "trip_date": "2026-03-03"},
+	} {
+		if status := do(t, s, "POST", "/v1/trips", token, trip, nil); status != fiber.StatusOK {
+			t.Fatalf("create trip %v: status %d", trip, status)
+		}
+	}
+
+	var problem Problem
+	if status := do(t, s, "POST", "/v1/trips", token, map[string]interface{}{"distance_km": 5, "mode_id": 99}, &problem); status != fiber.StatusUnprocessableEntity || problem.Code != "validation_failed" {
+		t.Errorf("unknown mode: got %d %q, want 422 validation_failed", status, problem.Code)
+	}
+
+	var trips struct {
+		Trips []tripResponse `json:"trips"`
+	}
+	if status := do(t, s, "GET", "/v1/trips", token, nil, &trips); status != fiber.StatusOK {
+		t.Fatalf("list trips: status %d", status)
+	}
+	if len(trips.Trips) != 2 {
+		t.Fatalf("got %d trips, want 2", len(trips.Trips))
+	}
+	var impact float64
+	for _, trip := range trips.Trips {
+		impact += *trip.CarbonImpactKg
+	}
+	if impact != 2 {
+		t.Errorf("total impact %v kg, want 2", impact)
+	}
+
+	var imperial struct {
+		Trips []tripResponse `json:"trips"`
+		Units struct {
+			Distance string `json:"distance"`
+		} `json:"units"`
+	}
+	do(t, s, "GET", "/v1/trips?units=imperial", token, nil, &imperial)
+	if imperial.Units.Distance != "mi" {
+		t.Errorf("imperial distance unit %q, want mi", imperial.Units.Distance)
+	}
+	for _, trip := range imperial.Trips {
+		if *trip.Distance >= *trip.DistanceKm {
+			t.Errorf("trip %d: %v mi for %v km", trip.TripID, *trip.Distance, *trip.DistanceKm)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	s := newTestServer(t)
+	token := signUp(t, s, "alice@example.com")
+
+	var problem Problem
+	if status := do(t, s, "GET", "/v1/user/budget", token, nil, &problem); status != fiber.StatusNotFound || problem.Code != "budget_not_found" {
+		t.Errorf("no budget: got %d %q, want 404 budget_not_found", status, problem.Code)
+	}
+
+	if status := do(t, s, "PUT", "/v1/user/budget", token, map[string]interface{}{"period": "yearly", "limit_kg": 100}, nil); status != fiber.StatusOK {
+		t.Fatalf("set budget: status %d", status)
+	}
+	if status := do(t, s, "POST", "/v1/trips", token, map[string]interface{}{"distance_km": 50, "mode_id": database.DefaultBaselineModeID}, nil); status != fiber.StatusOK {
+		t.Fatalf("create trip: status %d", status)
+	}
+
+	var budget struct {
+		Budget models.BudgetStatus `json:"budget"`
+	}
+	if status := do(t, s, "GET", "/v1/user/budget", token, nil, &budget); status != fiber.StatusOK {
+		t.Fatalf("get budget: status %d", status)
+	}
+	if budget.Budget.LimitKg != 100 || budget.Budget.ConsumedKg != 10 || budget.Budget.RemainingKg != 90 {
+		t.Errorf("budget %+v, want 10 of 100 kg consumed", budget.Budget)
+	}
+
+	var imperial struct {
+		Budget budgetResponse `json:"budget"`
+	}
+	do(t, s, "GET", "/v1/user/budget?units=imperial", token, nil, &imperial)
+	if pounds := 10 / units.KgPerPound; math.Abs(imperial.Budget.Consumed-pounds) > 1e-9 || imperial.Budget.ConsumedKg != 10 {
+		t.Errorf("imperial budget consumed %v lb (%v kg), want %v lb", imperial.Budget.Consumed, imperial.Budget.ConsumedKg, pounds)
+	}
+
+	if status := do(t, s, "DELETE", "/v1/user/budget", token, nil, nil); status != fiber.StatusOK {
+		t.Fatalf("delete budget: status %d", status)
+	}
+	if status := do(t, s, "GET", "/v1/user/budget", token, nil, &problem); status != fiber.StatusNotFound {
+		t.Errorf("deleted budget: status %d, want 404", status)
+	}
+}
+
+func TestBudgetAlerts(t *testing.T) {
+	s := newTestServer(t)
+	token := signUp(t, s, "alice@example.com")
+	user, err := s.store.Users.GetByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	french := "fr"
+	if err := s.store.Users.UpdateLanguage(context.Background(), user.UserID, &french); err != nil {
+		t.Fatal(err)
+	}
+
+	do(t, s, "PUT", "/v1/user/budget", token, map[string]interface{}{"period": "yearly", "limit_kg": 10}, nil)
+	do(t, s, "POST", "/v1/trips", token, map[string]interface{}{"distance_km": 50, "mode_id": database.DefaultBaselineModeID}, nil)
+
+	var notifications struct {
+		Notifications []models.Notification `json:"notifications"`
+	}
+	do(t, s, "GET", "/v1/user/notifications", token, nil, &notifications)
+	if len(notifications.Notifications) != len(database.BudgetThresholds) {
+		t.Fatalf("got %d notifications, want one by threshold", len(notifications.Notifications))
+	}
+	for _, notification := range notifications.Notifications {
+		if !strings.HasSuffix(notification.Message, "de votre budget carbone annuel (10,0 kg sur 10,0 kg).") {
+			t.Errorf("notification %q, want it in French", notification.Message)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	s := newTestServer(t)
+	token := signUp(t, s, "alice@example.com")
+	other := signUp(t, s, "bob@example.com")
+	do(t, s, "POST", "/v1/trips", token, map[string]interface{}{"distance_km": 10, "mode_id": 1}, nil)
+	do(t, s, "POST", "/v1/trips", other, map[string]interface{}{"distance_km": 20, "mode_id": 1}, nil)
+
+	req := httptest.NewRequest("GET", "/v1/user/export", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.App().Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("export: status %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"data.json", "trips.csv", "notifications.csv", "participations.csv", "recommendations.csv"} {
+		if files[name] == nil {
+			t.Errorf("%s missing from the export", name)
+		}
+	}
+	if files["data.json"] == nil {
+		return
+	}
+	r, err := files["data.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var data models.UserData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.User.Email != "alice@example.com" || len(data.Trips) != 1 || *data.Trips[0].DistanceKm != 10 {
+		t.Errorf("exported %s with %d trips, want alice@example.com with her 10 km trip", data.User.Email, len(data.Trips))
+	}
+}
+
+func TestReport(t *testing.T) {
+	s := newTestServer(t)
+	token := signUp(t, s, "alice@example.com")
+	do(t, s, "POST", "/v1/trips", token, map[string]interface{}{"distance_km": 10, "mode_id": 1, "trip_date": "2026-03-02"}, nil)
+
+	if status := do(t, s, "POST", "/v1/user/reports/2026-03", token, nil, nil); status != fiber.StatusAccepted {
+		t.Fatalf("request report: status %d", status)
+	}
+	// the report is generated in the background
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		req := httptest.NewRequest("GET", "/v1/user/reports/2026-03?format=html", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := s.App().Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == fiber.StatusOK {
+			if !bytes.Contains(body, []byte("March 2026")) {
+				t.Errorf("report without its month: %s", body)
+			}
+			return
+		}
+		if resp.StatusCode != fiber.StatusAccepted || time.Now().After(deadline) {
+			t.Fatalf("get report: status %d: %s", resp.StatusCode, body)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	s := newTestServer(t)
+	signUp(t, s, "alice@example.com")
+	ctx := context.Background()
+	user, err := s.store.Users.GetByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.reports.Request(ctx, user.UserID, "2026", "en"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		job, _, err := s.reports.Get(ctx, user.UserID, "2026")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == reports.StatusReady {
+			break
+		}
+		if job.Status == reports.StatusFailed || time.Now().After(deadline) {
+			t.Fatalf("report %s: %s", job.Status, job.Error)
+		}
+	}
+
+	if err := s.deleteAccount(ctx, user.UserID, func(tx *database.Store) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.Users.GetByID(ctx, user.UserID); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("user still there: %v", err)
+	}
+	if _, ok, _ := s.reports.Get(ctx, user.UserID, "2026"); ok {
+		t.Error("report job still there")
+	}
+	if _, err := s.reports.Open(ctx, user.UserID, "2026", "pdf"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("report file still there: %v", err)
+	}
+}
+
+// failingBlobs fails to store anything with an error that must not reach the
+// client
+type failingBlobs struct{ storage.Blobs }
+
+func (failingBlobs) Put(ctx context.Context, key string, content io.Reader) error {
+	return errors.New("write /srv/reports: no space left on device")
+}
+
+func TestReportFailed(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "test-secret-of-at-least-32-bytes!!"
+	store := database.NewMemoryStore(nil, testModes()...)
+	blobs := failingBlobs{storage.NewLocal(t.TempDir())}
+	s := NewServer(cfg, store, reports.NewGenerator(store, blobs, 1), blobs, mail.New(cfg.Mail))
+	token := signUp(t, s, "alice@example.com")
+
+	if status := do(t, s, "POST", "/v1/user/reports/2026", token, nil, nil); status != fiber.StatusAccepted {
+		t.Fatalf("request report: status %d", status)
+	}
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		var problem Problem
+		status := do(t, s, "GET", "/v1/user/reports/2026", token, nil, &problem)
+		if status == fiber.StatusAccepted && time.Now().Before(deadline) {
+			continue
+		}
+		if status != fiber.StatusInternalServerError || problem.Code != "report_failed" {
+			t.Fatalf("get report: status %d %s, want 500 report_failed", status, problem.Code)
+		}
+		if problem.Detail != "the report could not be generated" {
+			t.Errorf("detail %q, want the fixed message without the cause", problem.Detail)
+		}
+		return
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "test-secret-of-at-least-32-bytes!!"
+	cfg.Server.ProxyHeader = "X-Forwarded-For"
+	store := database.NewMemoryStore(nil, testModes()...)
+	blobs := storage.NewLocal(t.TempDir())
+	s := NewServer(cfg, store, reports.NewGenerator(store, blobs, 1), blobs, mail.New(cfg.Mail))
+	signUp(t, s, "alice@example.com")
+
+	login := func(ip, password string) int {
+		body, _ := json.Marshal(map[string]string{"email": "alice@example.com", "password": password})
+		req := httptest.NewRequest("POST", "/v1/auth/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := s.App().Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i := 0; i < cfg.RateLimit.LockoutThreshold; i++ {
+		if status := login("203.0.113.9", "wrong-password"); status != fiber.StatusUnauthorized {
+			t.Fatalf("failed login %d: status %d", i+1, status)
+		}
+	}
+	if status := login("203.0.113.9", "correct-horse-1"); status != fiber.StatusTooManyRequests {
+		t.Errorf("login from the locked out address: status %d, want 429", status)
+	}
+	if status := login("198.51.100.1", "correct-horse-1"); status != fiber.StatusOK {
+		t.Errorf("login from another address: status %d, want 200", status)
+	}
+}
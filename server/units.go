@@ -0,0 +1,152 @@
+package server
+
+import (
+	"API/models"
+	"API/units"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+// unitSystem returns the unit system of the request: the units query
+// parameter, else the preference of the user set by AuthMiddleware, else
+// metric
+func unitSystem(c *fiber.Ctx) (string, error) {
+	switch system := c.Query("units"); system {
+	case models.UnitsMetric, models.UnitsImperial:
+		return system, nil
+	case "":
+		if system, _ := c.Locals("units").(string); system != "" {
+			return system, nil
+		}
+		return models.UnitsMetric, nil
+	}
+	return "", invalidParameter("units must be %s or %s", models.UnitsMetric, models.UnitsImperial)
+}
+
+// tripResponse is a trip with its distance and impacts in the unit system of
+// the request, the _km and _kg fields stay metric, and its date in the time
+// zone of the user
+type tripResponse struct {
+	models.Trip
+	Distance       *float64 `json:"distance,omitempty"`
+	CarbonImpact   *float64 `json:"carbon_impact,omitempty"`
+	BaselineImpact *float64 `json:"baseline_impact,omitempty"`
+}
+
+func convertTrips(trips []models.Trip, system string, loc *time.Location) []tripResponse {
+	converted := make([]tripResponse, len(trips))
+	for i, trip := range trips {
+		trip.TripDate = trip.TripDate.In(loc)
+		converted[i] = tripResponse{
+			Trip:           trip,
+			Distance:       convertOptional(trip.DistanceKm, system, units.Distance),
+			CarbonImpact:   convertOptional(trip.CarbonImpactKg, system, units.Mass),
+			BaselineImpact: convertOptional(trip.BaselineImpactKg, system, units.Mass),
+		}
+	}
+	return converted
+}
+
+func convertOptional(value *float64, system string, convert func(float64, string) float64) *float64 {
+	if value == nil {
+		return nil
+	}
+	converted := convert(*value, system)
+	return &converted
+}
+
+func convertTripsByMode(trips []models.TripsByMode, system string) []models.TripsByMode {
+	for i := range trips {
+		trips[i].TotalImpact = units.Mass(trips[i].TotalImpact, system)
+		trips[i].TotalDistance = units.Distance(trips[i].TotalDistance, system)
+		trips[i].TotalAvoided = units.Mass(trips[i].TotalAvoided, system)
+	}
+	return trips
+}
+
+func convertSavings(savings models.Savings, system string) models.Savings {
+	savings.TotalImpact = units.Mass(savings.TotalImpact, system)
+	savings.TotalBaseline = units.Mass(savings.TotalBaseline, system)
+	savings.TotalAvoided = units.Mass(savings.TotalAvoided, system)
+	return savings
+}
+
+func convertPoints(points []Point, system string) []Point {
+	for i := range points {
+		points[i].Y = units.Mass(points[i].Y, system)
+		points[i].Avoided = units.Mass(points[i].Avoided, system)
+	}
+	return points
+}
+
+// monthlyEmissionResponse is a month of emissions with its impact in the unit
+// system of the request
+type monthlyEmissionResponse struct {
+	models.MonthlyEmission
+	Impact float64 `json:"impact"`
+}
+
+func convertMonthlyEmissions(months []models.MonthlyEmission, system string) []monthlyEmissionResponse {
+	converted := make([]monthlyEmissionResponse, len(months))
+	for i, month := range months {
+		converted[i] = monthlyEmissionResponse{MonthlyEmission: month, Impact: units.Mass(month.ImpactKg, system)}
+	}
+	return converted
+}
+
+// forecastResponse is the forecast with the impacts in the unit system of the
+// request
+type forecastResponse struct {
+	Method               string                    `json:"method"`
+	History              []monthlyEmissionResponse `json:"history"`
+	Forecast             []monthlyEmissionResponse `json:"forecast"`
+	MonthOverMonthChange *float64                  `json:"month_over_month_change"`
+	MovingAverage        []monthlyEmissionResponse `json:"moving_average"`
+	ModeShareDrift       []models.ModeShareDrift   `json:"mode_share_drift"`
+}
+
+func convertForecast(forecast *models.EmissionForecast, system string) forecastResponse {
+	return forecastResponse{
+		Method:               forecast.Method,
+		History:              convertMonthlyEmissions(forecast.History, system),
+		Forecast:             convertMonthlyEmissions(forecast.Forecast, system),
+		MonthOverMonthChange: forecast.Trend.MonthOverMonthChange,
+		MovingAverage:        convertMonthlyEmissions(forecast.Trend.MovingAverage, system),
+		ModeShareDrift:       forecast.Trend.ModeShareDrift,
+	}
+}
+
+// budgetResponse is the budget status with its masses in the unit system of
+// the request
+type budgetResponse struct {
+	models.BudgetStatus
+	Limit     float64 `json:"limit"`
+	Consumed  float64 `json:"consumed"`
+	Remaining float64 `json:"remaining"`
+	Projected float64 `json:"projected"`
+}
+
+func convertBudget(status *models.BudgetStatus, system string) budgetResponse {
+	return budgetResponse{
+		BudgetStatus: *status,
+		Limit:        units.Mass(status.LimitKg, system),
+		Consumed:     units.Mass(status.ConsumedKg, system),
+		Remaining:    units.Mass(status.RemainingKg, system),
+		Projected:    units.Mass(status.ProjectedKg, system),
+	}
+}
+
+// modeComparisonResponse is the impact of a mode in the unit system of the
+// request
+type modeComparisonResponse struct {
+	models.ModeComparison
+	CarbonImpact float64 `json:"carbon_impact"`
+}
+
+func convertModeComparisons(modes []models.ModeComparison, system string) []modeComparisonResponse {
+	converted := make([]modeComparisonResponse, len(modes))
+	for i, mode := range modes {
+		converted[i] = modeComparisonResponse{ModeComparison: mode, CarbonImpact: units.Mass(mode.CarbonImpactKg, system)}
+	}
+	return converted
+}
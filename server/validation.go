@@ -0,0 +1,204 @@
+package server
+
+import (
+	"API/database"
+	"API/i18n"
+	"API/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Password policy: bcrypt ignores everything after 72 bytes
+const (
+	passwordMinLength = 8
+	passwordMaxLength = 72
+)
+
+// FieldError describes why a field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// format and args give the message, translated for the client
+	format string
+	args   []interface{}
+}
+
+func fieldError(field, code, format string, args ...interface{}) FieldError {
+	message := format
+	if len(args) > 0 {
+		message = fmt.Sprintf(format, args...)
+	}
+	return FieldError{Field: field, Code: code, Message: message, format: format, args: args}
+}
+
+// localizeFields returns the fields with their messages in the language lang
+func localizeFields(fields []FieldError, lang string) []FieldError {
+	localized := make([]FieldError, len(fields))
+	for i, field := range fields {
+		localized[i] = field
+		localized[i].Message = i18n.T(lang, field.format, field.args...)
+	}
+	return localized
+}
+
+// errValidation is the problem returned with the list of invalid fields
+func errValidation(fields []FieldError) *Error {
+	err := newError(fiber.StatusUnprocessableEntity, "validation_failed", "the request has invalid fields")
+	err.Fields = fields
+	return err
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// report fields by their JSON or query name
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "query"} {
+			if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" && name != "-" {
+				return name
+			}
+		}
+		return field.Name
+	})
+
+	v.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		password := fl.Field().String()
+		if len(password) < passwordMinLength || len(password) > passwordMaxLength {
+			return false
+		}
+		var letter, digit bool
+		for _, r := range password {
+			letter = letter || unicode.IsLetter(r)
+			digit = digit || unicode.IsDigit(r)
+		}
+		return letter && digit
+	})
+	v.RegisterValidation("language", func(fl validator.FieldLevel) bool {
+		return i18n.Supported(fl.Field().String())
+	})
+	v.RegisterValidation("date", func(fl validator.FieldLevel) bool {
+		_, err := utils.ConvertStringToTime(fl.Field().String())
+		return err == nil
+	})
+	v.RegisterValidation("datetime", func(fl validator.FieldLevel) bool {
+		_, err := utils.ParseDateTime(fl.Field().String(), time.UTC)
+		return err == nil
+	})
+	return v
+}
+
+// parseBody decodes the JSON body into req and validates it
+func parseBody(c *fiber.Ctx, req interface{}) error {
+	if err := c.BodyParser(req); err != nil {
+		return errInvalidBody
+	}
+	return validateStruct(req)
+}
+
+// parseQuery decodes the query parameters into req and validates them
+func parseQuery(c *fiber.Ctx, req interface{}) error {
+	if err := c.QueryParser(req); err != nil {
+		return errInvalidQuery
+	}
+	return validateStruct(req)
+}
+
+func validateStruct(req interface{}) error {
+	err := validate.Struct(req)
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	fields := make([]FieldError, len(errs))
+	for i, fe := range errs {
+		format, args := fieldMessage(fe)
+		fields[i] = fieldError(fe.Field(), fe.Tag(), format, args...)
+	}
+	return errValidation(fields)
+}
+
+// fieldMessage explains a failed validation rule, with the format of the
+// message and its arguments
+func fieldMessage(fe validator.FieldError) (string, []interface{}) {
+	switch fe.Tag() {
+	case "required":
+		return "is required", nil
+	case "required_without":
+		return "is required when %s is not given", []interface{}{snakeCase(fe.Param())}
+	case "required_without_all":
+		names := strings.Fields(fe.Param())
+		for i, name := range names {
+			names[i] = snakeCase(name)
+		}
+		return "is required when none of %s is given", []interface{}{strings.Join(names, ", ")}
+	case "excluded_with":
+		return "must not be given with %s", []interface{}{snakeCase(fe.Param())}
+	case "email":
+		return "must be a valid email address", nil
+	case "password":
+		return "must be %d to %d characters long and contain a letter and a digit", []interface{}{passwordMinLength, passwordMaxLength}
+	case "date":
+		return "must be a date formatted as YYYY-MM-DD", nil
+	case "datetime":
+		return "must be a date formatted as YYYY-MM-DD or an RFC 3339 timestamp", nil
+	case "language":
+		return "is not a supported language", nil
+	case "timezone":
+		return "must be an IANA time zone, e.g. Europe/Paris", nil
+	case "oneof":
+		return "must be one of: %s", []interface{}{strings.ReplaceAll(fe.Param(), " ", ", ")}
+	case "min":
+		if fe.Kind() == reflect.String {
+			return "must be at least %s characters long", []interface{}{fe.Param()}
+		}
+		return "must be at least %s", []interface{}{fe.Param()}
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most %s characters long", []interface{}{fe.Param()}
+		}
+		return "must be at most %s", []interface{}{fe.Param()}
+	case "len":
+		return "must be %s characters long", []interface{}{fe.Param()}
+	case "gt":
+		return "must be greater than %s", []interface{}{fe.Param()}
+	case "gte":
+		return "must be greater than or equal to %s", []interface{}{fe.Param()}
+	case "lte":
+		return "must be less than or equal to %s", []interface{}{fe.Param()}
+	}
+	return "is invalid", nil
+}
+
+var upperCase = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+// snakeCase turns the Go field names given as rule parameters into JSON names
+func snakeCase(name string) string {
+	return strings.ToLower(upperCase.ReplaceAllString(name, "${1}_${2}"))
+}
+
+// checkModeAvailable reports an unknown or a disabled transportation mode as
+// an invalid field
+func (s *Server) checkModeAvailable(ctx context.Context, field string, modeID int) error {
+	mode, err := s.store.Modes.GetByID(ctx, modeID)
+	if errors.Is(err, database.ErrModeNotFound) {
+		return errValidation([]FieldError{fieldError(field, "exists", "is not a known transportation mode")})
+	}
+	if err != nil {
+		return err
+	}
+	if !mode.Enabled {
+		return errValidation([]FieldError{fieldError(field, "enabled", "is a disabled transportation mode")})
+	}
+	return nil
+}
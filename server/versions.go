@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"time"
+)
+
+// apiVersion is a version of the API mounted under /<name>. A new version
+// gets its own routes function, calling the handlers that did not change and
+// new ones for the changed responses, and is served side by side with the
+// previous versions until their sunset.
+type apiVersion struct {
+	name   string
+	routes func(r fiber.Router)
+	docs   []routeDoc
+	// deprecatedAt and sunset are set once a newer version replaces this one
+	deprecatedAt time.Time
+	sunset       time.Time
+	successor    string
+}
+
+// The routes were served at the root before /v1, the aliases are kept until
+// the mobile apps have moved to /v1
+var (
+	legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacySunset       = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// versions lists the versions of the API, oldest first
+func (s *Server) versions() []apiVersion {
+	return []apiVersion{
+		{name: "v1", routes: s.v1Routes, docs: routeDocs},
+	}
+}
+
+func (v apiVersion) prefix() string {
+	return "/" + v.name
+}
+
+func (v apiVersion) deprecated() bool {
+	return !v.deprecatedAt.IsZero()
+}
+
+// registerVersions mounts every version and, unless disabled, the root aliases
+// of the first one
+func (s *Server) registerVersions() {
+	versions := s.versions()
+	for _, version := range versions {
+		group := s.app.Group(version.prefix())
+		if version.deprecated() {
+			successor := "/" + version.successor
+			group.Use(DeprecationMiddleware(version.deprecatedAt, version.sunset, func(path string) string {
+				return successor + strings.TrimPrefix(path, version.prefix())
+			}))
+		}
+		version.routes(group)
+	}
+
+	if s.cfg.Server.LegacyRoutes {
+		legacy := versions[0]
+		s.app.Use(legacyPrefixes(legacy.docs), DeprecationMiddleware(legacyDeprecatedAt, legacySunset, func(path string) string {
+			return legacy.prefix() + path
+		}))
+		legacy.routes(s.app)
+	}
+}
+
+// legacyPrefixes returns the first path segment of every route, the prefixes
+// the root aliases are served under
+func legacyPrefixes(docs []routeDoc) []string {
+	seen := make(map[string]bool)
+	var prefixes []string
+	for _, doc := range docs {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(doc.path, "/"), "/")
+		if !seen[segment] {
+			seen[segment] = true
+			prefixes = append(prefixes, "/"+segment)
+		}
+	}
+	return prefixes
+}
+
+// DeprecationMiddleware announces that the routes are deprecated with the
+// Deprecation (RFC 9745) and Sunset (RFC 8594) headers, and links to the same
+// route in the version replacing them
+func DeprecationMiddleware(deprecatedAt, sunset time.Time, successor func(path string) string) fiber.Handler {
+	deprecation := fmt.Sprintf("@%d", deprecatedAt.Unix())
+	sunsetDate := sunset.UTC().Format(time.RFC1123)
+	sunsetDate = strings.Replace(sunsetDate, "UTC", "GMT", 1)
+	return func(c *fiber.Ctx) error {
+		c.Set("Deprecation", deprecation)
+		c.Set("Sunset", sunsetDate)
+		c.Set(fiber.HeaderLink, fmt.Sprintf(`<%s>; rel="successor-version"`, successor(c.Path())))
+		return c.Next()
+	}
+}
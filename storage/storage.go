@@ -0,0 +1,109 @@
+// Package storage keeps the files uploaded by the users, e.g. the avatars,
+// outside of the database
+package storage
+
+import (
+	"API/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotFound is returned for a key without a blob
+var ErrNotFound = errors.New("blob not found")
+
+// Blobs stores blobs by key, a slash separated path such as avatars/1.png
+type Blobs interface {
+	// Put creates or replaces the blob of key with the content
+	Put(ctx context.Context, key string, content io.Reader) error
+	// Get opens the blob of key, to be closed by the caller
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob of key, if any
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the blob storage of the configured backend
+func New(cfg config.StorageConfig) (Blobs, error) {
+	switch cfg.Backend {
+	case "local":
+		return NewLocal(cfg.Dir), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+}
+
+// Local stores the blobs as files under a directory
+type Local struct {
+	dir string
+}
+
+// NewLocal returns the storage of the blobs under dir, created when needed
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// path returns the file of key, refusing the keys escaping the directory
+func (l *Local) path(key string) (string, error) {
+	if key == "" || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid blob key: %q", key)
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, content io.Reader) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	// write a temporary file renamed once complete, so that readers never
+	// see a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return file, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Package units converts the distances and masses between the metric units
+// the API stores and the unit system of the users
+package units
+
+import "API/models"
+
+// Conversion factors of the imperial units
+const (
+	KmPerMile  = 1.609344
+	KgPerPound = 0.45359237
+)
+
+// Labels names a unit system and the symbols of its units, sent along the
+// converted values
+type Labels struct {
+	System   string `json:"system"`
+	Distance string `json:"distance"`
+	Mass     string `json:"mass"`
+}
+
+// Of returns the labels of system, metric unless it is imperial
+func Of(system string) Labels {
+	if system == models.UnitsImperial {
+		return Labels{System: models.UnitsImperial, Distance: "mi", Mass: "lb"}
+	}
+	return Labels{System: models.UnitsMetric, Distance: "km", Mass: "kg"}
+}
+
+// Distance converts km to the distance unit of system
+func Distance(km float64, system string) float64 {
+	if system == models.UnitsImperial {
+		return km / KmPerMile
+	}
+	return km
+}
+
+// Mass converts kg to the mass unit of system
+func Mass(kg float64, system string) float64 {
+	if system == models.UnitsImperial {
+		return kg / KgPerPound
+	}
+	return kg
+}
+
+// ToKg converts a mass in the unit of system to kg
+func ToKg(mass float64, system string) float64 {
+	if system == models.UnitsImperial {
+		return mass * KgPerPound
+	}
+	return mass
+}
+
+// ToKm converts a distance in the unit of system to km
+func ToKm(distance float64, system string) float64 {
+	if system == models.UnitsImperial {
+		return distance * KmPerMile
+	}
+	return distance
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// coordinates is a geocoded address
+type coordinates struct {
+	lat, lng float64
+}
+
+type cacheEntry struct {
+	address   string
+	location  coordinates
+	expiresAt time.Time
+}
+
+// geocodingCache keeps the most recently geocoded addresses for ttl, so that
+// the addresses the users travel between every day are geocoded once. The
+// least recently used address is evicted once size is reached.
+type geocodingCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+func newGeocodingCache(size int, ttl time.Duration) *geocodingCache {
+	return &geocodingCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *geocodingCache) get(address string) (coordinates, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[address]
+	if !ok {
+		return coordinates{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, address)
+		return coordinates{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.location, true
+}
+
+func (c *geocodingCache) set(address string, location coordinates) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := time.Now().Add(c.ttl)
+	if element, ok := c.entries[address]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.location = location
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[address] = c.order.PushFront(&cacheEntry{address: address, location: location, expiresAt: expiresAt})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).address)
+	}
+}
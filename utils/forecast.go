@@ -0,0 +1,73 @@
+package utils
+
+import "math"
+
+// SeasonalNaiveForecast repeats the value observed one season earlier.
+// The history must hold at least one full season.
+func SeasonalNaiveForecast(history []float64, season, horizon int) []float64 {
+	forecast := make([]float64, horizon)
+	if season <= 0 || len(history) < season {
+		return forecast
+	}
+	series := append([]float64{}, history...)
+	for i := 0; i < horizon; i++ {
+		value := series[len(series)-season]
+		forecast[i] = value
+		series = append(series, value)
+	}
+	return forecast
+}
+
+// HoltForecast applies Holt's linear exponential smoothing with the given level
+// (alpha) and trend (beta) factors. Negative projections are clamped to 0.
+func HoltForecast(history []float64, alpha, beta float64, horizon int) []float64 {
+	forecast := make([]float64, horizon)
+	if len(history) == 0 {
+		return forecast
+	}
+	level := history[0]
+	trend := 0.0
+	if len(history) > 1 {
+		trend = history[1] - history[0]
+	}
+	for _, value := range history[1:] {
+		previousLevel := level
+		level = alpha*value + (1-alpha)*(level+trend)
+		trend = beta*(level-previousLevel) + (1-beta)*trend
+	}
+	for i := range forecast {
+		forecast[i] = math.Max(0, level+float64(i+1)*trend)
+	}
+	return forecast
+}
+
+// MovingAverage returns the trailing average over window values for each point,
+// averaging over fewer values at the start of the series.
+func MovingAverage(values []float64, window int) []float64 {
+	averages := make([]float64, len(values))
+	if window <= 0 {
+		return averages
+	}
+	var sum float64
+	for i, value := range values {
+		sum += value
+		if i >= window {
+			sum -= values[i-window]
+		}
+		n := i + 1
+		if n > window {
+			n = window
+		}
+		averages[i] = sum / float64(n)
+	}
+	return averages
+}
+
+// PercentChange returns the change from previous to current in percent.
+// The boolean is false when previous is 0.
+func PercentChange(previous, current float64) (float64, bool) {
+	if previous == 0 {
+		return 0, false
+	}
+	return (current - previous) / previous * 100, true
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+// equal reports whether a and b hold the same values, up to rounding errors
+func equal(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSeasonalNaiveForecast(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []float64
+		season  int
+		horizon int
+		want    []float64
+	}{
+		{"repeats the last season", []float64{1, 2, 3, 4}, 2, 3, []float64{3, 4, 3}},
+		{"history shorter than the season", []float64{1, 2}, 3, 2, []float64{0, 0}},
+		{"no season", []float64{1, 2}, 0, 2, []float64{0, 0}},
+		{"horizon of 0", []float64{1, 2, 3, 4}, 2, 0, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SeasonalNaiveForecast(tt.history, tt.season, tt.horizon); !equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHoltForecast(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []float64
+		horizon int
+		want    []float64
+	}{
+		{"follows the trend", []float64{1, 2, 3}, 2, []float64{4, 5}},
+		{"clamps to 0", []float64{3, 2, 1}, 2, []float64{0, 0}},
+		{"single value", []float64{5}, 2, []float64{5, 5}},
+		{"no history", nil, 2, []float64{0, 0}},
+		{"horizon of 0", []float64{1, 2, 3}, 0, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HoltForecast(tt.history, 0.5, 0.5, tt.horizon); !equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMovingAverage(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		window int
+		want   []float64
+	}{
+		{"trailing average", []float64{2, 4, 6}, 2, []float64{2, 3, 5}},
+		{"window larger than the input", []float64{2, 4, 6}, 5, []float64{2, 3, 4}},
+		{"no window", []float64{2, 4, 6}, 0, []float64{0, 0, 0}},
+		{"no values", nil, 2, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MovingAverage(tt.values, tt.window); !equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous float64
+		current  float64
+		want     float64
+		ok       bool
+	}{
+		{"increase", 50, 75, 50, true},
+		{"decrease", 100, 0, -100, true},
+		{"zero previous value", 0, 10, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := PercentChange(tt.previous, tt.current); got != tt.want || ok != tt.ok {
+				t.Errorf("got %v %v, want %v %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -1,20 +1,137 @@
 package utils
 
 import (
+	"API/config"
+	"API/metrics"
+	"API/telemetry"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"math"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+var (
+	// ErrProviderUnavailable is returned when a provider cannot be reached or
+	// gives an unusable response
+	ErrProviderUnavailable = errors.New("provider unavailable")
+	// ErrAddressNotFound is returned when an address cannot be geocoded
+	ErrAddressNotFound = errors.New("address not found")
+)
+
+// Client calls the geocoding and Impact CO₂ APIs
+type Client struct {
+	// httpClient is shared by the outbound calls. Its timeout is a fallback
+	// for callers whose context has no deadline.
+	httpClient       *http.Client
+	googleMapsAPIKey string
+	geocodingURL     string
+	impactCO2URL     string
+	// geocoding is nil when the cache is disabled
+	geocoding *geocodingCache
+}
+
+// NewClient returns a Client for the configured providers
+func NewClient(cfg config.ProvidersConfig) *Client {
+	client := &Client{
+		httpClient:       &http.Client{Timeout: cfg.HTTPTimeout},
+		googleMapsAPIKey: cfg.GoogleMapsAPIKey,
+		geocodingURL:     cfg.GeocodingURL,
+		impactCO2URL:     cfg.ImpactCO2URL,
+	}
+	if cfg.GeocodingCacheSize > 0 {
+		client.geocoding = newGeocodingCache(cfg.GeocodingCacheSize, cfg.GeocodingCacheTTL)
+	}
+	return client
+}
+
+// Names of the providers in the spans, logs and metrics
+const (
+	providerGeocoding = "geocoding"
+	providerImpactCO2 = "impactco2"
+)
+
+// get sends a GET request to the provider that is cancelled along with ctx.
+// The call is traced, and carries the trace context and the ID of the request
+// being served, and counted in the provider metrics. Failures and non-200
+// responses are reported as ErrProviderUnavailable.
+func (c *Client) get(ctx context.Context, provider, url string) (*http.Response, error) {
+	ctx, span := telemetry.Tracer().Start(ctx, "GET "+provider, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if requestID := telemetry.RequestID(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	span.SetAttributes(attribute.String("http.request.method", http.MethodGet), attribute.String("server.address", req.URL.Host))
+
+	resp, err := c.httpClient.Do(req)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	}
+	metrics.ProviderRequestDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ProviderRequests.WithLabelValues(provider, "error").Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		slog.WarnContext(ctx, "Provider call failed", "provider", provider, "duration_ms", time.Since(start).Milliseconds(), "error", err)
+		return nil, fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+	metrics.ProviderRequests.WithLabelValues(provider, "ok").Inc()
+	slog.DebugContext(ctx, "Provider call", "provider", provider, "duration_ms", time.Since(start).Milliseconds())
+	return resp, nil
+}
+
+// Check calls every provider and returns, by provider name, nil for those
+// that answered and the error of the others
+func (c *Client) Check(ctx context.Context) map[string]error {
+	// without an address the geocoding API answers INVALID_REQUEST, enough to
+	// know that it is reachable
+	resp, geocodingErr := c.get(ctx, providerGeocoding, c.geocodingURL)
+	if geocodingErr == nil {
+		resp.Body.Close()
+	}
+	// the impact of 1 km in a petrol car
+	_, impactCO2Err := c.GetCarbonImpactByMode(ctx, 4, 1)
+	return map[string]error{
+		providerGeocoding: geocodingErr,
+		providerImpactCO2: impactCO2Err,
+	}
+}
+
 func ConvertStringToTime(date string) (time.Time, error) {
 	return time.Parse("2006-01-02", date)
 }
 
+// ParseDateTime parses an RFC 3339 timestamp with its offset, or a YYYY-MM-DD
+// date taken at midnight in loc
+func ParseDateTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
 func CalculateCarCarbonFootprint(carBrand, carModel string, distanceKm float64) (float64, error) {
 	// call the gcp cloud function to calculate the carbon impact for the car
 	return 0, nil
@@ -41,33 +158,93 @@ type Co2Response struct {
 	} `json:"data"`
 }
 
-func GetCarbonImpactByMode(modeID int, distanceKm float64) (float64, error) {
-	baseURL := "https://impactco2.fr/api/v1/transport"
+// GetCarbonImpactByMode returns the carbon impact in kg of the Impact CO₂
+// transport ID for the distance
+func (c *Client) GetCarbonImpactByMode(ctx context.Context, transportID int, distanceKm float64) (float64, error) {
+	impacts, err := c.GetCarbonImpactForModes(ctx, []int{transportID}, distanceKm)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := impacts[transportID]
+	if !ok {
+		return 0, fmt.Errorf("%w: no CO₂ data returned for transport ID: %d", ErrProviderUnavailable, transportID)
+	}
+	return value, nil
+}
+
+// GetCarbonImpactForModes queries Impact CO₂ once for several transport IDs and
+// returns the carbon impact in kg for each of them, keyed by transport ID.
+func (c *Client) GetCarbonImpactForModes(ctx context.Context, transportIDs []int, distanceKm float64) (map[int]float64, error) {
+	if len(transportIDs) == 0 {
+		return map[int]float64{}, nil
+	}
+	ids := make([]string, len(transportIDs))
+	for i, id := range transportIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+
 	params := url.Values{}
 	params.Add("km", strconv.FormatFloat(distanceKm, 'f', 2, 64))
 	params.Add("displayAll", "0")
-	params.Add("transports", strconv.Itoa(modeID))
+	params.Add("transports", strings.Join(ids, ","))
 	params.Add("ignoreRadiativeForcing", "0")
 	params.Add("occupencyRate", "1")
 	params.Add("includeConstruction", "0")
-	params.Add("language", "fr")
 
-	resp, err := http.Get(baseURL + "?" + params.Encode())
+	resp, err := c.get(ctx, providerImpactCO2, c.impactCO2URL+"?"+params.Encode())
 	if err != nil {
-		return 0, fmt.Errorf("failed to call Impact CO₂ API: %w", err)
+		return nil, fmt.Errorf("failed to call Impact CO₂ API: %w", err)
 	}
 	defer resp.Body.Close()
 
 	var result Co2Response
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 0, fmt.Errorf("failed to parse CO₂ response: %w", err)
+		return nil, fmt.Errorf("%w: failed to parse CO₂ response: %w", ErrProviderUnavailable, err)
 	}
 
 	if len(result.Data) == 0 {
-		return 0, fmt.Errorf("no CO₂ data returned for transport ID: %d", modeID)
+		return nil, fmt.Errorf("%w: no CO₂ data returned for transport IDs: %s", ErrProviderUnavailable, strings.Join(ids, ","))
+	}
+
+	impacts := make(map[int]float64, len(result.Data))
+	for _, d := range result.Data {
+		impacts[d.ID] = d.Value
 	}
+	return impacts, nil
+}
 
-	return result.Data[0].Value, nil
+// averageSpeedKmh holds typical door-to-door speeds for the Impact CO₂
+// transport IDs, used to give a rough travel duration estimate.
+var averageSpeedKmh = map[int]float64{
+	1:  500, // plane
+	2:  230, // high speed train
+	3:  110, // intercity train
+	4:  60,  // petrol car
+	5:  60,  // electric car
+	6:  70,  // coach
+	7:  15,  // bike
+	8:  20,  // e-bike
+	9:  18,  // bus
+	10: 20,  // tramway
+	11: 30,  // metro
+	12: 35,  // scooter
+	13: 50,  // motorbike
+	14: 45,  // suburban train
+	15: 75,  // regional train
+	16: 18,  // electric bus
+	17: 15,  // e-scooter
+	21: 18,  // CNG bus
+	30: 5,   // walking
+}
+
+// EstimateDurationMinutes returns an estimated travel time for the given
+// transport ID and distance. The boolean is false when no speed is known.
+func EstimateDurationMinutes(transportID int, distanceKm float64) (float64, bool) {
+	speed, ok := averageSpeedKmh[transportID]
+	if !ok || speed <= 0 {
+		return 0, false
+	}
+	return distanceKm / speed * 60, true
 }
 
 func HaversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
@@ -84,13 +261,21 @@ func HaversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	return EarthRadius * c
 }
 
-func GetCoordinates(address, apiKey string) (float64, float64, error) {
-	baseURL := "https://maps.googleapis.com/maps/api/geocode/json"
+// GetCoordinates geocodes the address, from the cache when it was geocoded recently
+func (c *Client) GetCoordinates(ctx context.Context, address string) (float64, float64, error) {
+	if c.geocoding != nil {
+		location, ok := c.geocoding.get(address)
+		metrics.CacheLookup(providerGeocoding, ok)
+		if ok {
+			return location.lat, location.lng, nil
+		}
+	}
+
 	params := url.Values{}
 	params.Add("address", address)
-	params.Add("key", apiKey)
+	params.Add("key", c.googleMapsAPIKey)
 
-	resp, err := http.Get(baseURL + "?" + params.Encode())
+	resp, err := c.get(ctx, providerGeocoding, c.geocodingURL+"?"+params.Encode())
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to call geocoding API: %w", err)
 	}
@@ -98,25 +283,27 @@ func GetCoordinates(address, apiKey string) (float64, float64, error) {
 
 	var result GeocodingResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 0, 0, fmt.Errorf("failed to parse geocoding response: %w", err)
+		return 0, 0, fmt.Errorf("%w: failed to parse geocoding response: %w", ErrProviderUnavailable, err)
 	}
 
 	if len(result.Results) == 0 {
-		return 0, 0, fmt.Errorf("no results found for address: %s", address)
+		return 0, 0, fmt.Errorf("%w: %s", ErrAddressNotFound, address)
 	}
 
 	location := result.Results[0].Geometry.Location
+	if c.geocoding != nil {
+		c.geocoding.set(address, coordinates{lat: location.Lat, lng: location.Lng})
+	}
 	return location.Lat, location.Lng, nil
 }
 
-func CalculateDistance(startAddress, endAddress string) (float64, error) {
-	key := os.Getenv("GOOGLE_MAPS_API_KEY")
-	startLat, startLng, err := GetCoordinates(startAddress, key)
+func (c *Client) CalculateDistance(ctx context.Context, startAddress, endAddress string) (float64, error) {
+	startLat, startLng, err := c.GetCoordinates(ctx, startAddress)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get start coordinates: %w", err)
 	}
 
-	endLat, endLng, err := GetCoordinates(endAddress, key)
+	endLat, endLng, err := c.GetCoordinates(ctx, endAddress)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get end coordinates: %w", err)
 	}